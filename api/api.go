@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// API serves the HTTP endpoints:
+//
+//	/healthz       200 "ok" if reports are being written, else 503 and why
+//	/status        JSON status of collectors, queue, aggregator and sinks, and the config
+//	/api/v1/query  JSON stats of stored reports; see query()
+//	/api/v1/raw    JSON raw samples; see raw()
+//	/grafana/      Grafana simple-JSON datasource; see grafana.go
+type API struct {
+	addr       string
+	origin     string // Access-Control-Allow-Origin of /grafana/, empty = no CORS
+	collectors *mm.Registry
+	queue      *mm.Queue
+	aggregator *mm.Aggregator
+	sinks      *mm.Dispatcher
+	reader     mm.Reader    // nil if storage can't be queried
+	rawReader  mm.RawReader // nil if no sink stores raw samples
+	config     interface{}  // with passwords hidden
+	started    time.Time
+	listener   net.Listener
+	mux        *http.ServeMux
+}
+
+// NewAPI returns an API that queries the first sink that can be queried.
+// grafanaOrigin is the origin allowed to call /grafana/ from a browser, e.g.
+// https://grafana.example.com, or empty to allow none.
+func NewAPI(addr, grafanaOrigin string, collectors *mm.Registry, queue *mm.Queue, aggregator *mm.Aggregator, sinks *mm.Dispatcher, config interface{}) *API {
+	a := &API{
+		addr:       addr,
+		origin:     grafanaOrigin,
+		collectors: collectors,
+		queue:      queue,
+		aggregator: aggregator,
+		sinks:      sinks,
+		reader:     sinks.Reader(),
+		rawReader:  sinks.RawReader(),
+		config:     config,
+		mux:        http.NewServeMux(),
+	}
+	a.mux.HandleFunc("/healthz", a.healthz)
+	a.mux.HandleFunc("/status", a.status)
+	a.mux.HandleFunc("/api/v1/query", a.query)
+	a.mux.HandleFunc("/api/v1/raw", a.raw)
+	a.mux.HandleFunc("/grafana/", a.grafana(a.grafanaTest))
+	a.mux.HandleFunc("/grafana/search", a.grafana(a.grafanaSearch))
+	a.mux.HandleFunc("/grafana/query", a.grafana(a.grafanaQuery))
+	a.mux.HandleFunc("/grafana/annotations", a.grafana(a.grafanaAnnotations))
+	return a
+}
+
+func (a *API) Start() error {
+	l, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return err
+	}
+	a.listener = l
+	a.started = time.Now()
+	go func() {
+		if err := http.Serve(l, a.mux); err != nil {
+			log.Debug("API stopped: ", err)
+		}
+	}()
+	log.Info("API listening on ", l.Addr())
+	return nil
+}
+
+func (a *API) Stop() {
+	if a.listener != nil {
+		a.listener.Close()
+	}
+}
+
+func (a *API) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := a.healthy(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+type status struct {
+	Started    time.Time
+	Healthy    bool
+	Error      string `json:",omitempty"`
+	Collectors map[string]map[string]string
+	Aggregator map[string]string
+	Sinks      map[string]string
+	Queue      map[string]interface{}
+	Config     interface{}
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request) {
+	s := status{
+		Started:    a.started.UTC(),
+		Healthy:    true,
+		Collectors: a.collectors.Status(),
+		Aggregator: a.aggregator.Status(),
+		Sinks:      a.sinks.Status(),
+		Queue: map[string]interface{}{
+			"Length":  a.queue.Len(),
+			"Size":    a.queue.Size(),
+			"Policy":  a.queue.Policy(),
+			"Dropped": a.queue.Dropped(),
+		},
+		Config: a.config,
+	}
+	if err := a.healthy(); err != nil {
+		s.Healthy = false
+		s.Error = err.Error()
+	}
+	writeJSON(w, s)
+}
+
+// healthy returns an error if aggregation or any sink is failing.
+func (a *API) healthy() error {
+	if err := a.aggregator.Healthy(); err != nil {
+		return err
+	}
+	return a.sinks.Healthy()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"../mm"
+)
+
+// The Grafana simple-JSON datasource protocol, so dashboards can use the
+// collector as a datasource with URL http://<API addr>/grafana.  See
+// https://github.com/grafana/simple-json-datasource.
+//
+// A query target is a metric name with optional ;key=value options:
+//
+//	mysql/threads_running
+//	mysql/table/rows;instance=db1;stat=max
+//
+// where instance is the collector name (default all) and stat is one of
+// cnt, min, pct5, avg (default), med, pct95 or max.  An annotation query is
+// an event type (restart, variable, or empty for all) with the same instance
+// option, e.g. restart;instance=db1.
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+type grafanaQueryRequest struct {
+	Range      grafanaRange `json:"range"`
+	IntervalMs int64        `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, Unix ms]
+}
+
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange    `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"` // echoed from the request
+	Time       int64           `json:"time"`       // Unix ms
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// grafana wraps a datasource handler.  If origin is set, it adds the
+// CORS headers Grafana needs to call the collector directly from a browser
+// at that origin; with server (proxy) access, Grafana needs none.
+func (a *API) grafana(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", a.origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "accept, content-type")
+			w.Header().Set("Vary", "Origin")
+			if r.Method == "OPTIONS" {
+				return
+			}
+		}
+		if a.reader == nil {
+			http.Error(w, "Storage does not support queries", http.StatusNotImplemented)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// grafanaTest answers the "Save & Test" of the datasource.
+func (a *API) grafanaTest(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/grafana/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// grafanaSearch returns the metric names matching the target, which is a
+// prefix or, if it has a *, a pattern like Query.Name.
+func (a *API) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	req := grafanaSearchRequest{}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pattern := req.Target
+	if !strings.Contains(pattern, "*") {
+		pattern += "*"
+	}
+	re := regexp.MustCompile(mm.Query{Name: pattern}.NameRegexp())
+
+	names, err := a.reader.Names("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	matches := []string{}
+	for _, name := range names {
+		if re.MatchString(name) {
+			matches = append(matches, name)
+		}
+	}
+	writeJSON(w, matches)
+}
+
+func (a *API) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	req := grafanaQueryRequest{}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := []grafanaSeries{}
+	for _, t := range req.Targets {
+		if t.Type == "table" {
+			http.Error(w, "Table queries are not supported", http.StatusBadRequest)
+			return
+		}
+		name, opts, err := parseTarget(t.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name == "" || t.Hide {
+			continue
+		}
+		stat := opts["stat"]
+		if stat == "" {
+			stat = "avg"
+		}
+		if _, ok := (&mm.Point{}).Stat(stat); !ok {
+			http.Error(w, "Invalid stat: "+stat, http.StatusBadRequest)
+			return
+		}
+
+		q := mm.Query{
+			Name:       name,
+			Instance:   opts["instance"],
+			From:       req.Range.From.UTC(),
+			To:         req.Range.To.UTC(),
+			Resolution: req.IntervalMs / 1000,
+		}
+		series, err := a.reader.Read(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, s := range series {
+			gs := grafanaSeries{
+				Target:     s.Instance + " " + mm.MetricKey(s.Name, s.Labels),
+				Datapoints: make([][2]float64, len(s.Points)),
+			}
+			if stat != "avg" {
+				gs.Target += " " + stat
+			}
+			for i, p := range s.Points {
+				v, _ := p.Stat(stat)
+				gs.Datapoints[i] = [2]float64{v, float64(p.Ts.UnixNano() / int64(time.Millisecond))}
+			}
+			result = append(result, gs)
+		}
+	}
+	writeJSON(w, result)
+}
+
+func (a *API) grafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	req := grafanaAnnotationRequest{}
+	if err := readJSON(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	annotation := struct {
+		Query string `json:"query"`
+	}{}
+	if len(req.Annotation) > 0 {
+		if err := json.Unmarshal(req.Annotation, &annotation); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	eventType, opts, err := parseTarget(annotation.Query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := mm.Query{
+		Name:     eventType,
+		Instance: opts["instance"],
+		From:     req.Range.From.UTC(),
+		To:       req.Range.To.UTC(),
+	}
+	events, err := a.reader.Events(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := make([]grafanaAnnotation, len(events))
+	for i, e := range events {
+		result[i] = grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       e.Ts * 1000,
+			Title:      e.Instance + " " + e.Type,
+			Text:       e.Text,
+			Tags:       []string{e.Instance, e.Type},
+		}
+	}
+	writeJSON(w, result)
+}
+
+// parseTarget parses "name[;key=value...]" into the name and options.
+func parseTarget(target string) (string, map[string]string, error) {
+	parts := strings.Split(strings.TrimSpace(target), ";")
+	opts := make(map[string]string)
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, fmt.Errorf("Invalid option in %s: %s; expected key=value", target, opt)
+		}
+		switch key := strings.TrimSpace(kv[0]); key {
+		case "instance", "stat":
+			opts[key] = strings.TrimSpace(kv[1])
+		default:
+			return "", nil, fmt.Errorf("Unknown option in %s: %s", target, key)
+		}
+	}
+	return strings.TrimSpace(parts[0]), opts, nil
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if r.Method != "POST" {
+		return fmt.Errorf("Expected POST, got %s", r.Method)
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("Invalid JSON request: %s", err)
+	}
+	return nil
+}
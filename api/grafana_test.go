@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+// A fakeReader is a sink that answers queries with fixed data, or err, and
+// records the queries.
+type fakeReader struct {
+	series  []*mm.Series
+	names   []string
+	events  []mm.Event
+	samples []mm.Sample
+	err     error
+	queries []mm.Query
+}
+
+var fakeReaders = map[string]*fakeReader{}
+
+func init() {
+	mm.RegisterSink("fake", func(name string, data []byte) (mm.Sink, error) {
+		r := &fakeReader{}
+		fakeReaders[name] = r
+		return r, nil
+	})
+}
+
+func (r *fakeReader) Write(service string, report *mm.Report) error { return nil }
+func (r *fakeReader) Close()                                        {}
+
+func (r *fakeReader) Read(q mm.Query) ([]*mm.Series, error) {
+	r.queries = append(r.queries, q)
+	return r.series, r.err
+}
+
+func (r *fakeReader) Names(instance string) ([]string, error) {
+	return r.names, nil
+}
+
+func (r *fakeReader) Events(q mm.Query) ([]mm.Event, error) {
+	r.queries = append(r.queries, q)
+	return r.events, nil
+}
+
+func (r *fakeReader) WriteRaw(samples []mm.Sample) error { return nil }
+
+func (r *fakeReader) ReadRaw(q mm.Query) ([]mm.Sample, error) {
+	r.queries = append(r.queries, q)
+	return r.samples, r.err
+}
+
+var (
+	from = time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	to   = time.Date(2020, 9, 13, 13, 0, 0, 0, time.UTC)
+)
+
+func newTestAPI(t *testing.T, origin string) (*API, *fakeReader) {
+	sinks, err := mm.NewDispatcher([]mm.SinkConfig{{Type: "fake", Name: t.Name(), Raw: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sinks.Close)
+	r := fakeReaders[t.Name()]
+	r.names = []string{"mysql/table/rows", "mysql/threads_running", "os/loadavg/1"}
+	r.series = []*mm.Series{
+		{
+			Instance: "db1",
+			Name:     "mysql/table/rows",
+			Labels:   map[string]string{"schema": "s1", "table": "t1"},
+			Points: []*mm.Point{
+				{Ts: time.Unix(1600000000, 0).UTC(), Cnt: 2, Min: 1, Avg: 1.5, Max: 2},
+				{Ts: time.Unix(1600000060, 0).UTC(), Cnt: 2, Min: 3, Avg: 3.5, Max: 4},
+			},
+		},
+	}
+	r.events = []mm.Event{
+		{Ts: 1600000000, Instance: "db1", Type: "restart", Text: "mysqld restarted"},
+	}
+	return NewAPI("localhost:0", origin, nil, nil, nil, sinks, nil), r
+}
+
+func post(a *API, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.mux.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, expected 200", w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGrafanaSearch(t *testing.T) {
+	a, _ := newTestAPI(t, "")
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"", []string{"mysql/table/rows", "mysql/threads_running", "os/loadavg/1"}},
+		{"mysql/", []string{"mysql/table/rows", "mysql/threads_running"}},
+		{"*/1", []string{"os/loadavg/1"}},
+		{"mysql/t*s", []string{"mysql/table/rows"}},
+		{"nope", []string{}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		decode(t, post(a, "/grafana/search", `{"target":"`+test.target+`"}`), &got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("search %q: got %v, expected %v", test.target, got, test.want)
+		}
+	}
+}
+
+func TestGrafanaQuery(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	tests := []struct {
+		targets string
+		query   mm.Query // zero if no query is expected
+		want    []grafanaSeries
+	}{
+		{
+			`{"target":"mysql/table/rows"}`,
+			mm.Query{Name: "mysql/table/rows", From: from, To: to, Resolution: 60},
+			[]grafanaSeries{
+				{"db1 mysql/table/rows{schema=s1,table=t1}", [][2]float64{{1.5, 1600000000000}, {3.5, 1600000060000}}},
+			},
+		},
+		{
+			`{"target":"mysql/table/rows; instance=db1 ;stat=max"}`,
+			mm.Query{Name: "mysql/table/rows", Instance: "db1", From: from, To: to, Resolution: 60},
+			[]grafanaSeries{
+				{"db1 mysql/table/rows{schema=s1,table=t1} max", [][2]float64{{2, 1600000000000}, {4, 1600000060000}}},
+			},
+		},
+		{
+			`{"target":"mysql/table/rows;stat=cnt"}`,
+			mm.Query{Name: "mysql/table/rows", From: from, To: to, Resolution: 60},
+			[]grafanaSeries{
+				{"db1 mysql/table/rows{schema=s1,table=t1} cnt", [][2]float64{{2, 1600000000000}, {2, 1600000060000}}},
+			},
+		},
+		{
+			// Hidden and empty targets aren't queried.
+			`{"target":"mysql/table/rows","hide":true},{"target":""}`,
+			mm.Query{},
+			[]grafanaSeries{},
+		},
+	}
+	for _, test := range tests {
+		r.queries = nil
+		body := `{"range":{"from":"2020-09-13T12:00:00Z","to":"2020-09-13T13:00:00Z"},"intervalMs":60000,"targets":[` + test.targets + `]}`
+		got := []grafanaSeries{}
+		decode(t, post(a, "/grafana/query", body), &got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.targets, got, test.want)
+		}
+		switch {
+		case test.query.Name == "" && len(r.queries) != 0:
+			t.Errorf("%s: got queries %+v, expected none", test.targets, r.queries)
+		case test.query.Name != "" && (len(r.queries) != 1 || !reflect.DeepEqual(r.queries[0], test.query)):
+			t.Errorf("%s: got queries %+v, expected %+v", test.targets, r.queries, test.query)
+		}
+	}
+}
+
+func TestGrafanaQueryErrors(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"targets":[{"target":"mysql/table/rows;stat=p99"}]}`, "Invalid stat: p99"},
+		{`{"targets":[{"target":"mysql/table/rows","type":"table"}]}`, "Table queries are not supported"},
+		{`{"targets":[{"target":"mysql/table/rows;max"}]}`, "Invalid option in mysql/table/rows;max: max; expected key=value"},
+		{`{"targets":[{"target":"mysql/table/rows;host=db1"}]}`, "Unknown option in mysql/table/rows;host=db1: host"},
+		{`{"targets":`, "Invalid JSON request: unexpected EOF"},
+	}
+	for _, test := range tests {
+		w := post(a, "/grafana/query", test.body)
+		if w.Code != http.StatusBadRequest || strings.TrimSpace(w.Body.String()) != test.err {
+			t.Errorf("%s: got %d %s, expected 400 %s", test.body, w.Code, w.Body, test.err)
+		}
+	}
+	if len(r.queries) != 0 {
+		t.Errorf("got queries %+v, expected none", r.queries)
+	}
+}
+
+func TestGrafanaAnnotations(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	annotation := `{"name":"restarts","query":"restart;instance=db1"}`
+	body := `{"range":{"from":"2020-09-13T12:00:00Z","to":"2020-09-13T13:00:00Z"},"annotation":` + annotation + `}`
+	got := []grafanaAnnotation{}
+	decode(t, post(a, "/grafana/annotations", body), &got)
+	for i := range got {
+		// The echoed annotation is indented like the rest of the response.
+		var b bytes.Buffer
+		json.Compact(&b, got[i].Annotation)
+		got[i].Annotation = b.Bytes()
+	}
+	want := []grafanaAnnotation{
+		{
+			Annotation: json.RawMessage(annotation),
+			Time:       1600000000000,
+			Title:      "db1 restart",
+			Text:       "mysqld restarted",
+			Tags:       []string{"db1", "restart"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+	query := mm.Query{Name: "restart", Instance: "db1", From: from, To: to}
+	if len(r.queries) != 1 || !reflect.DeepEqual(r.queries[0], query) {
+		t.Errorf("got queries %+v, expected %+v", r.queries, query)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		name   string
+		opts   map[string]string
+		err    bool
+	}{
+		{"mysql/threads_running", "mysql/threads_running", map[string]string{}, false},
+		{" mysql/table/rows ; instance = db1 ; stat = max ", "mysql/table/rows", map[string]string{"instance": "db1", "stat": "max"}, false},
+		{"restart;instance=", "restart", map[string]string{"instance": ""}, false},
+		{"", "", map[string]string{}, false},
+		{"x;stat", "", nil, true},
+		{"x;foo=bar", "", nil, true},
+	}
+	for _, test := range tests {
+		name, opts, err := parseTarget(test.target)
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v", test.target, err)
+			continue
+		}
+		if name != test.name || !reflect.DeepEqual(opts, test.opts) {
+			t.Errorf("%q: got %q %v, expected %q %v", test.target, name, opts, test.name, test.opts)
+		}
+	}
+}
+
+func TestGrafanaCORS(t *testing.T) {
+	for _, origin := range []string{"", "https://grafana.example.com"} {
+		a, _ := newTestAPI(t, origin)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("OPTIONS", "/grafana/query", nil)
+		req.Header.Set("Origin", "https://grafana.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		a.mux.ServeHTTP(w, req)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != origin {
+			t.Errorf("origin %q: got Access-Control-Allow-Origin %q", origin, got)
+		}
+		if origin == "" {
+			// No CORS: the preflight is a query like any other, and isn't a POST.
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("origin %q: got %d, expected 400", origin, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("origin %q: got %d %s, expected 200 and no body", origin, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, OPTIONS" {
+			t.Errorf("origin %q: got Access-Control-Allow-Methods %q", origin, got)
+		}
+
+		// The query itself gets the header too.
+		w = post(a, "/grafana/query", `{"targets":[]}`)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != origin {
+			t.Errorf("origin %q: query got %d and Access-Control-Allow-Origin %q", origin, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"../mm"
+)
+
+// Default time range of a query if from isn't given.
+const defaultQueryRange = time.Hour
+
+// query returns the stats of stored reports as a list of mm.Series.
+// Parameters:
+//
+//	name        metric name, * matches any characters (required)
+//	instance    collector name, default all
+//	from, to    RFC3339 or Unix timestamp, default the last hour
+//	resolution  seconds per point, default as reported
+func (a *API) query(w http.ResponseWriter, r *http.Request) {
+	if a.reader == nil {
+		http.Error(w, "Storage does not support queries", http.StatusNotImplemented)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := a.reader.Read(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, series)
+}
+
+// A rawSample is an mm.Sample without NaN, which JSON can't encode.
+type rawSample struct {
+	Ts       int64
+	Instance string
+	Name     string
+	Labels   map[string]string `json:",omitempty"`
+	Type     string
+	Value    float64
+	Rate     *float64 `json:",omitempty"` // counters, if known
+}
+
+// raw returns the raw samples of a sink with Raw as a list of rawSample.
+// Parameters are the same as query but resolution is ignored.
+func (a *API) raw(w http.ResponseWriter, r *http.Request) {
+	if a.rawReader == nil {
+		http.Error(w, "No sink stores raw samples", http.StatusNotImplemented)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	samples, err := a.rawReader.ReadRaw(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows := make([]rawSample, len(samples))
+	for i, s := range samples {
+		rows[i] = rawSample{
+			Ts:       s.Ts,
+			Instance: s.Instance,
+			Name:     s.Name,
+			Labels:   s.Labels,
+			Type:     s.Type,
+			Value:    s.Value,
+		}
+		if s.Type == "counter" && !math.IsNaN(s.Rate) {
+			rate := s.Rate
+			rows[i].Rate = &rate
+		}
+	}
+	writeJSON(w, rows)
+}
+
+func parseQuery(r *http.Request) (mm.Query, error) {
+	v := r.URL.Query()
+	q := mm.Query{
+		Name:     v.Get("name"),
+		Instance: v.Get("instance"),
+	}
+	if q.Name == "" {
+		return q, fmt.Errorf("name is required")
+	}
+
+	var err error
+	q.To = time.Now().UTC()
+	if to := v.Get("to"); to != "" {
+		if q.To, err = parseTime(to); err != nil {
+			return q, fmt.Errorf("Invalid to: %s", err)
+		}
+	}
+	q.From = q.To.Add(-defaultQueryRange)
+	if from := v.Get("from"); from != "" {
+		if q.From, err = parseTime(from); err != nil {
+			return q, fmt.Errorf("Invalid from: %s", err)
+		}
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	if res := v.Get("resolution"); res != "" {
+		if q.Resolution, err = strconv.ParseInt(res, 10, 64); err != nil || q.Resolution < 0 {
+			return q, fmt.Errorf("Invalid resolution: %s", res)
+		}
+	}
+	return q, nil
+}
+
+// parseTime parses RFC3339 or a Unix timestamp.
+func parseTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+func get(a *API, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestQuery(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	tests := []struct {
+		path  string
+		query mm.Query
+	}{
+		{
+			"/api/v1/query?name=mysql/table/*&instance=db1&from=2020-09-13T12:00:00Z&to=2020-09-13T13:00:00Z&resolution=300",
+			mm.Query{Name: "mysql/table/*", Instance: "db1", From: from, To: to, Resolution: 300},
+		},
+		{
+			"/api/v1/query?name=mysql/table/rows&from=1599998400&to=1600002000",
+			mm.Query{Name: "mysql/table/rows", From: from, To: to},
+		},
+		{
+			// The hour before to.
+			"/api/v1/query?name=mysql/table/rows&to=2020-09-13T13:00:00Z",
+			mm.Query{Name: "mysql/table/rows", From: from, To: to},
+		},
+	}
+	for _, test := range tests {
+		r.queries = nil
+		got := []*mm.Series{}
+		decode(t, get(a, test.path), &got)
+		if !reflect.DeepEqual(got, r.series) {
+			t.Errorf("%s: got %+v, expected %+v", test.path, got, r.series)
+		}
+		if len(r.queries) != 1 || !reflect.DeepEqual(r.queries[0], test.query) {
+			t.Errorf("%s: got queries %+v, expected %+v", test.path, r.queries, test.query)
+		}
+	}
+
+	// The last hour.
+	r.queries = nil
+	decode(t, get(a, "/api/v1/query?name=mysql/table/rows"), &[]*mm.Series{})
+	if len(r.queries) != 1 || r.queries[0].To.Sub(r.queries[0].From) != time.Hour || time.Since(r.queries[0].To) > time.Minute {
+		t.Errorf("got queries %+v, expected the last hour", r.queries)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	tests := []struct {
+		path string
+		code int
+		err  string
+	}{
+		{"/api/v1/query", http.StatusBadRequest, "name is required"},
+		{"/api/v1/query?name=x&from=yesterday", http.StatusBadRequest, "Invalid from: "},
+		{"/api/v1/query?name=x&to=2020-09-13", http.StatusBadRequest, "Invalid to: "},
+		{"/api/v1/query?name=x&from=1600002000&to=1600002000", http.StatusBadRequest, "from must be before to"},
+		{"/api/v1/query?name=x&resolution=-60", http.StatusBadRequest, "Invalid resolution: -60"},
+		{"/api/v1/query?name=x&resolution=1m", http.StatusBadRequest, "Invalid resolution: 1m"},
+		{"/api/v1/raw?name=x&from=2020-09-13T14:00:00Z&to=2020-09-13T13:00:00Z", http.StatusBadRequest, "from must be before to"},
+	}
+	for _, test := range tests {
+		w := get(a, test.path)
+		if w.Code != test.code || !strings.HasPrefix(w.Body.String(), test.err) {
+			t.Errorf("%s: got %d %s, expected %d %s", test.path, w.Code, w.Body, test.code, test.err)
+		}
+	}
+	if len(r.queries) != 0 {
+		t.Errorf("got queries %+v, expected none", r.queries)
+	}
+
+	r.err = errors.New("disk on fire")
+	for _, path := range []string{"/api/v1/query?name=x", "/api/v1/raw?name=x"} {
+		if w := get(a, path); w.Code != http.StatusInternalServerError || strings.TrimSpace(w.Body.String()) != "disk on fire" {
+			t.Errorf("%s: got %d %s, expected 500 disk on fire", path, w.Code, w.Body)
+		}
+	}
+
+	// No sink can be queried.
+	a.reader, a.rawReader = nil, nil
+	for _, path := range []string{"/api/v1/query?name=x", "/api/v1/raw?name=x"} {
+		if w := get(a, path); w.Code != http.StatusNotImplemented {
+			t.Errorf("%s: got %d %s, expected 501", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	a, r := newTestAPI(t, "")
+	r.samples = []mm.Sample{
+		{Ts: 1600000000, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 100, Rate: math.NaN()},
+		{Ts: 1600000001, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 110, Rate: 10},
+		{Ts: 1600000001, Instance: "db1", Name: "mysql/table/rows", Labels: map[string]string{"table": "t1"}, Type: "gauge", Value: 5},
+	}
+	got := []rawSample{}
+	decode(t, get(a, "/api/v1/raw?name=mysql/*&instance=db1&from=2020-09-13T12:00:00Z&to=2020-09-13T13:00:00Z"), &got)
+
+	// No rate for the first value of a counter nor for a gauge.
+	rate := 10.0
+	want := []rawSample{
+		{Ts: 1600000000, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 100},
+		{Ts: 1600000001, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 110, Rate: &rate},
+		{Ts: 1600000001, Instance: "db1", Name: "mysql/table/rows", Labels: map[string]string{"table": "t1"}, Type: "gauge", Value: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+	query := mm.Query{Name: "mysql/*", Instance: "db1", From: from, To: to}
+	if len(r.queries) != 1 || !reflect.DeepEqual(r.queries[0], query) {
+		t.Errorf("got queries %+v, expected %+v", r.queries, query)
+	}
+}
@@ -0,0 +1,415 @@
+package boltSink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+func init() {
+	mm.RegisterSink("bolt", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewBoltSink(name, config)
+	})
+}
+
+// Most records Read returns, to protect the collector from huge queries.
+const maxReadRecords = 500000
+
+// Buckets are per UTC day, e.g. data/20060102, or hour for raw samples,
+// e.g. raw/2006010215, so old ones are dropped whole:
+//
+//	data/<day>    ts (8 bytes) + instance + \x00 + metric key = record
+//	events/<day>  ts (8 bytes) + sequence (8 bytes) = JSON event
+//	names/<day>   instance + \x00 + metric name = empty
+//	raw/<hour>    ts (8 bytes) + instance + \x00 + metric key = sample
+const (
+	dataBucket   = "data/"
+	eventsBucket = "events/"
+	namesBucket  = "names/"
+	rawBucket    = "raw/"
+	dayFormat    = "20060102"
+	hourFormat   = "2006010215"
+)
+
+// BoltSink stores reports and events in a local bbolt database file and
+// reads them back, so one collector can keep and serve several days of
+// history without MongoDB.  Unless KeepValues, only the stats of each
+// report are stored, so percentiles at lower resolutions are estimates.
+// With Raw, it also keeps RawRetentionHours of raw samples.
+type BoltSink struct {
+	name      string
+	config    *Config
+	db        *bolt.DB
+	pruned    string // day of the last prune
+	prunedRaw string // hour of the last raw prune
+}
+
+func NewBoltSink(name string, config *Config) (*BoltSink, error) {
+	db, err := bolt.Open(config.Path, 0600, &bolt.Options{Timeout: time.Duration(config.Timeout) * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %s: %s", config.Path, err)
+	}
+	s := &BoltSink{
+		name:   name,
+		config: config,
+		db:     db,
+	}
+	return s, nil
+}
+
+func (s *BoltSink) Write(service string, report *mm.Report) error {
+	log.Debug("BoltSink.Write:call")
+	defer log.Debug("BoltSink.Write:return")
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		day := report.Ts.UTC().Format(dayFormat)
+		if len(report.Stats) > 0 {
+			data, err := tx.CreateBucketIfNotExists([]byte(dataBucket + day))
+			if err != nil {
+				return err
+			}
+			names, err := tx.CreateBucketIfNotExists([]byte(namesBucket + day))
+			if err != nil {
+				return err
+			}
+			for _, is := range report.Stats {
+				for key, stats := range is.Stats {
+					rec := &record{
+						Duration: report.Duration,
+						Name:     stats.Name,
+						Labels:   stats.Labels,
+						Cnt:      stats.Cnt,
+						Min:      stats.Min,
+						Pct5:     stats.Pct5,
+						Avg:      stats.Avg,
+						Med:      stats.Med,
+						Pct95:    stats.Pct95,
+						Max:      stats.Max,
+					}
+					if s.config.KeepValues {
+						rec.Values = stats.Vals
+					}
+					if err := data.Put(dataKey(report.Ts, is.Instance, key), rec.encode()); err != nil {
+						return err
+					}
+					if err := names.Put([]byte(is.Instance+"\x00"+stats.Name), []byte{}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, e := range report.Events {
+			events, err := tx.CreateBucketIfNotExists([]byte(eventsBucket + time.Unix(e.Ts, 0).UTC().Format(dayFormat)))
+			if err != nil {
+				return err
+			}
+			seq, err := events.NextSequence()
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 16)
+			binary.BigEndian.PutUint64(key, uint64(e.Ts))
+			binary.BigEndian.PutUint64(key[8:], seq)
+			if err := events.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if today := time.Now().UTC().Format(dayFormat); today != s.pruned {
+		if err := s.prune(); err != nil {
+			log.Warn(fmt.Sprintf("Cannot remove old days from %s: %s", s.config.Path, err))
+		} else {
+			s.pruned = today
+		}
+	}
+	return nil
+}
+
+func (s *BoltSink) Close() {
+	s.db.Close()
+}
+
+func (s *BoltSink) WriteRaw(samples []mm.Sample) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var b *bolt.Bucket
+		var hour string
+		for i := range samples {
+			sample := &samples[i]
+			if h := time.Unix(sample.Ts, 0).UTC().Format(hourFormat); h != hour || b == nil {
+				var err error
+				if b, err = tx.CreateBucketIfNotExists([]byte(rawBucket + h)); err != nil {
+					return err
+				}
+				hour = h
+			}
+			key := dataKey(time.Unix(sample.Ts, 0), sample.Instance, mm.MetricKey(sample.Name, sample.Labels))
+			if err := b.Put(key, encodeSample(sample)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if hour := time.Now().UTC().Format(hourFormat); s.config.RawRetentionHours > 0 && hour != s.prunedRaw {
+		cutoff := time.Now().UTC().Add(-time.Duration(s.config.RawRetentionHours) * time.Hour).Format(hourFormat)
+		if err := s.deleteBuckets(rawBucket, cutoff); err != nil {
+			log.Warn(fmt.Sprintf("Cannot remove old raw samples from %s: %s", s.config.Path, err))
+		} else {
+			s.prunedRaw = hour
+		}
+	}
+	return nil
+}
+
+func (s *BoltSink) ReadRaw(q mm.Query) ([]mm.Sample, error) {
+	nameRe, err := regexp.Compile(q.NameRegexp())
+	if err != nil {
+		return nil, err
+	}
+	samples := []mm.Sample{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return forEachBucket(tx, rawBucket, hourFormat, time.Hour, q, func(k, v []byte) error {
+			instance, _ := splitKey(k[8:])
+			if q.Instance != "" && instance != q.Instance {
+				return nil
+			}
+			sample, err := decodeSample(v)
+			if err != nil {
+				return err
+			}
+			if !nameRe.MatchString(sample.Name) {
+				return nil
+			}
+			if len(samples) == maxReadRecords {
+				return fmt.Errorf("Query matches more than %d samples; narrow the name or time range", maxReadRecords)
+			}
+			sample.Ts = int64(binary.BigEndian.Uint64(k))
+			sample.Instance = instance
+			samples = append(samples, *sample)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// prune deletes the report, event and name buckets of days older than
+// Retention.
+func (s *BoltSink) prune() error {
+	cutoff := time.Now().UTC().AddDate(0, 0, -s.config.Retention).Format(dayFormat)
+	for _, prefix := range []string{dataBucket, eventsBucket, namesBucket} {
+		if err := s.deleteBuckets(prefix, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBuckets deletes the buckets named prefix<period> with period before
+// cutoff.
+func (s *BoltSink) deleteBuckets(prefix, cutoff string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old := [][]byte{}
+		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if bytes.HasPrefix(name, []byte(prefix)) && string(name[len(prefix):]) < cutoff {
+				old = append(old, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		for _, name := range old {
+			log.Info(fmt.Sprintf("Removing %s from %s", name, s.config.Path))
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltSink) Read(q mm.Query) ([]*mm.Series, error) {
+	nameRe, err := regexp.Compile(q.NameRegexp())
+	if err != nil {
+		return nil, err
+	}
+	stored := []mm.StoredValues{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return forEachBucket(tx, dataBucket, dayFormat, 24*time.Hour, q, func(k, v []byte) error {
+			instance, _ := splitKey(k[8:])
+			if q.Instance != "" && instance != q.Instance {
+				return nil
+			}
+			rec, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			if !nameRe.MatchString(rec.Name) {
+				return nil
+			}
+			if len(stored) == maxReadRecords {
+				return fmt.Errorf("Query matches more than %d records; narrow the name or time range", maxReadRecords)
+			}
+			stored = append(stored, mm.StoredValues{
+				Ts:       time.Unix(int64(binary.BigEndian.Uint64(k)), 0).UTC(),
+				Instance: instance,
+				Name:     rec.Name,
+				Labels:   rec.Labels,
+				Values:   rec.Values,
+				Summary: &mm.Point{
+					Cnt:   rec.Cnt,
+					Min:   rec.Min,
+					Pct5:  rec.Pct5,
+					Avg:   rec.Avg,
+					Med:   rec.Med,
+					Pct95: rec.Pct95,
+					Max:   rec.Max,
+				},
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mm.Rollup(stored, q.Resolution), nil
+}
+
+func (s *BoltSink) Names(instance string) ([]string, error) {
+	seen := map[string]bool{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !strings.HasPrefix(string(name), namesBucket) {
+				return nil
+			}
+			return b.ForEach(func(k, v []byte) error {
+				i, n := splitKey(k)
+				if instance == "" || i == instance {
+					seen[n] = true
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *BoltSink) Events(q mm.Query) ([]mm.Event, error) {
+	var typeRe *regexp.Regexp
+	if q.Name != "" {
+		var err error
+		if typeRe, err = regexp.Compile(q.NameRegexp()); err != nil {
+			return nil, err
+		}
+	}
+	events := []mm.Event{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return forEachBucket(tx, eventsBucket, dayFormat, 24*time.Hour, q, func(k, v []byte) error {
+			var e mm.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if q.Instance != "" && e.Instance != q.Instance {
+				return nil
+			}
+			if typeRe != nil && !typeRe.MatchString(e.Type) {
+				return nil
+			}
+			if len(events) == maxReadRecords {
+				return fmt.Errorf("Query matches more than %d events; narrow the time range", maxReadRecords)
+			}
+			events = append(events, e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// --------------------------------------------------------------------------
+
+// forEachBucket calls fn, in key order, for every key from q.From to q.To in
+// the buckets named prefix<period>, where every period is as long as step
+// and named per format.  Keys start with an 8 byte ts.
+func forEachBucket(tx *bolt.Tx, prefix, format string, step time.Duration, q mm.Query, fn func(k, v []byte) error) error {
+	from := make([]byte, 8)
+	binary.BigEndian.PutUint64(from, uint64(q.From.Unix()))
+	to := uint64(q.To.Unix())
+	for period := q.From.UTC().Truncate(step); period.Before(q.To); period = period.Add(step) {
+		b := tx.Bucket([]byte(prefix + period.Format(format)))
+		if b == nil {
+			continue
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(from); k != nil; k, v = c.Next() {
+			if len(k) < 8 {
+				continue
+			}
+			if binary.BigEndian.Uint64(k) >= to {
+				break
+			}
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dataKey(ts time.Time, instance, metricKey string) []byte {
+	key := make([]byte, 8, 8+len(instance)+1+len(metricKey))
+	binary.BigEndian.PutUint64(key, uint64(ts.Unix()))
+	key = append(key, instance...)
+	key = append(key, 0)
+	return append(key, metricKey...)
+}
+
+// splitKey splits "a\x00b" into a and b.
+func splitKey(k []byte) (string, string) {
+	i := bytes.IndexByte(k, 0)
+	if i < 0 {
+		return string(k), ""
+	}
+	return string(k[:i]), string(k[i+1:])
+}
@@ -0,0 +1,328 @@
+package boltSink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"../mm"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRecordCodec(t *testing.T) {
+	records := []*record{
+		{Duration: 60, Name: "mysql/threads_running", Cnt: 2, Min: 1, Pct5: 1, Avg: 1.5, Med: 2, Pct95: 2, Max: 2},
+		{
+			Duration: 300,
+			Name:     "mysql/table/rows",
+			Labels:   map[string]string{"schema": "db", "table": "t"},
+			Cnt:      3,
+			Min:      -1,
+			Pct5:     -1,
+			Avg:      1e100,
+			Med:      2,
+			Pct95:    math.Inf(1),
+			Max:      math.Inf(1),
+			Values:   []float64{-1, 2, math.Inf(1)},
+		},
+	}
+	for _, r := range records {
+		data := r.encode()
+		got, err := decodeRecord(data)
+		if err != nil {
+			t.Fatalf("%s: %s", r.Name, err)
+		}
+		if !reflect.DeepEqual(got, r) {
+			t.Errorf("got %+v, expected %+v", got, r)
+		}
+		for i := 0; i < len(data); i++ {
+			if _, err := decodeRecord(data[:i]); err != errCorrupt {
+				t.Errorf("%s: %d of %d bytes: got error %v, expected %v", r.Name, i, len(data), err, errCorrupt)
+			}
+		}
+	}
+
+	// A value count larger than the data left.
+	data := (&record{Name: "x"}).encode()
+	data = append(data[:len(data)-1], 0xff, 0x01)
+	if _, err := decodeRecord(data); err != errCorrupt {
+		t.Errorf("got error %v, expected %v", err, errCorrupt)
+	}
+}
+
+func TestSampleCodec(t *testing.T) {
+	samples := []*mm.Sample{
+		{Name: "mysql/threads_running", Type: "gauge", Value: 3},
+		{Name: "mysql/questions", Labels: map[string]string{"a": "1", "b": ""}, Type: "counter", Value: 1000, Rate: 12.5},
+	}
+	for _, s := range samples {
+		data := encodeSample(s)
+		got, err := decodeSample(data)
+		if err != nil {
+			t.Fatalf("%s: %s", s.Name, err)
+		}
+		if !reflect.DeepEqual(got, s) {
+			t.Errorf("got %+v, expected %+v", got, s)
+		}
+		for i := 0; i < len(data); i++ {
+			if _, err := decodeSample(data[:i]); err != errCorrupt {
+				t.Errorf("%s: %d of %d bytes: got error %v, expected %v", s.Name, i, len(data), err, errCorrupt)
+			}
+		}
+	}
+
+	// The NaN rate of a first counter value.
+	got, err := decodeSample(encodeSample(&mm.Sample{Name: "mysql/questions", Type: "counter", Value: 1, Rate: math.NaN()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !math.IsNaN(got.Rate) {
+		t.Errorf("got rate %f, expected NaN", got.Rate)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	for _, keepValues := range []bool{false, true} {
+		s, cleanup := newTestSink(t)
+		s.config.KeepValues = keepValues
+
+		// Reports must be recent or the sink prunes them.
+		ts := time.Now().UTC().Truncate(time.Hour).Add(-time.Hour)
+		labels := map[string]string{"schema": "db", "table": "t"}
+		reports := []*mm.Report{
+			{
+				Ts:       ts,
+				Duration: 60,
+				Stats: []*mm.InstanceStats{
+					{Instance: "db1", Stats: map[string]*mm.Stats{
+						"mysql/threads_running":               {Name: "mysql/threads_running", Type: "gauge", Vals: []float64{1, 2, 3}, Cnt: 3, Min: 1, Pct5: 1, Avg: 2, Med: 2, Pct95: 3, Max: 3},
+						"mysql/table/rows{schema=db,table=t}": {Name: "mysql/table/rows", Labels: labels, Type: "gauge", Vals: []float64{100}, Cnt: 1, Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100},
+					}},
+					{Instance: "db2", Stats: map[string]*mm.Stats{
+						"mysql/threads_running": {Name: "mysql/threads_running", Type: "gauge", Vals: []float64{9}, Cnt: 1, Min: 9, Pct5: 9, Avg: 9, Med: 9, Pct95: 9, Max: 9},
+					}},
+				},
+				Events: []mm.Event{
+					{Ts: ts.Unix() + 10, Instance: "db1", Type: mm.EventRestart, Text: "restarted"},
+					{Ts: ts.Unix() + 20, Instance: "db2", Type: mm.EventVariable, Text: "max_connections changed from 151 to 500"},
+				},
+			},
+			{
+				Ts:       ts.Add(time.Minute),
+				Duration: 60,
+				Stats: []*mm.InstanceStats{
+					{Instance: "db1", Stats: map[string]*mm.Stats{
+						"mysql/threads_running": {Name: "mysql/threads_running", Type: "gauge", Vals: []float64{3, 4, 5}, Cnt: 3, Min: 3, Pct5: 3, Avg: 4, Med: 4, Pct95: 5, Max: 5},
+						// Collected every 300s, carried forward.
+						"mysql/table/rows{schema=db,table=t}": {Name: "mysql/table/rows", Labels: labels, Type: "gauge", Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100, Carried: true},
+					}},
+				},
+			},
+		}
+		for _, r := range reports {
+			if err := s.Write("mm", r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// As reported.
+		q := mm.Query{Name: "mysql/*", Instance: "db1", From: ts, To: ts.Add(time.Hour)}
+		got, err := s.Read(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []*mm.Series{
+			{Instance: "db1", Name: "mysql/table/rows", Labels: labels, Points: []*mm.Point{
+				{Ts: ts, Cnt: 1, Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100},
+				{Ts: ts.Add(time.Minute), Cnt: 0, Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100},
+			}},
+			{Instance: "db1", Name: "mysql/threads_running", Points: []*mm.Point{
+				{Ts: ts, Cnt: 3, Min: 1, Pct5: 1, Avg: 2, Med: 2, Pct95: 3, Max: 3},
+				{Ts: ts.Add(time.Minute), Cnt: 3, Min: 3, Pct5: 3, Avg: 4, Med: 4, Pct95: 5, Max: 5},
+			}},
+		}
+		if !keepValues && !reflect.DeepEqual(got, expect) {
+			t.Errorf("got %s, expected %s", dump(got), dump(expect))
+		}
+		if keepValues && (len(got) != 2 || len(got[1].Points) != 2 || got[1].Points[1].Cnt != 3 || got[1].Points[1].Max != 5) {
+			t.Errorf("KeepValues: got %s", dump(got))
+		}
+
+		// Both reports in one point; the carried stats add no values.
+		q.Name = "mysql/*_running"
+		q.Resolution = 120
+		got, err = s.Read(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || len(got[0].Points) != 1 {
+			t.Fatalf("got %s, expected 1 series of 1 point", dump(got))
+		}
+		p := got[0].Points[0]
+		if !p.Ts.Equal(ts) || p.Cnt != 6 || p.Min != 1 || p.Avg != 3 || p.Max != 5 {
+			t.Errorf("KeepValues=%t: got %+v, expected ts %s, cnt 6, min 1, avg 3, max 5", keepValues, p, ts)
+		}
+
+		// Not in the time range.
+		q.From, q.To = ts.Add(-time.Hour), ts
+		if got, err = s.Read(q); err != nil || len(got) != 0 {
+			t.Errorf("got %s, %v; expected no series", dump(got), err)
+		}
+
+		names, err := s.Names("db1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := []string{"mysql/table/rows", "mysql/threads_running"}; !reflect.DeepEqual(names, expect) {
+			t.Errorf("got names %v, expected %v", names, expect)
+		}
+		if names, _ = s.Names("db2"); !reflect.DeepEqual(names, []string{"mysql/threads_running"}) {
+			t.Errorf("got names %v, expected [mysql/threads_running]", names)
+		}
+
+		q = mm.Query{From: ts, To: ts.Add(time.Hour)}
+		events, err := s.Events(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(events, reports[0].Events) {
+			t.Errorf("got events %+v, expected %+v", events, reports[0].Events)
+		}
+		q.Name = mm.EventVariable
+		if events, _ = s.Events(q); !reflect.DeepEqual(events, reports[0].Events[1:]) {
+			t.Errorf("got events %+v, expected %+v", events, reports[0].Events[1:])
+		}
+		q.Name, q.Instance = "", "db1"
+		if events, _ = s.Events(q); !reflect.DeepEqual(events, reports[0].Events[:1]) {
+			t.Errorf("got events %+v, expected %+v", events, reports[0].Events[:1])
+		}
+
+		cleanup()
+	}
+}
+
+func TestWriteReadRaw(t *testing.T) {
+	s, cleanup := newTestSink(t)
+	defer cleanup()
+
+	ts := time.Now().UTC().Truncate(time.Hour).Unix()
+	samples := []mm.Sample{
+		{Ts: ts - 1, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 100, Rate: 10},
+		{Ts: ts, Instance: "db1", Name: "mysql/questions", Type: "counter", Value: 110, Rate: 10},
+		{Ts: ts, Instance: "db1", Name: "mysql/table/rows", Labels: map[string]string{"table": "t"}, Type: "gauge", Value: 5},
+		{Ts: ts, Instance: "db2", Name: "mysql/questions", Type: "counter", Value: 7, Rate: 1},
+	}
+	if err := s.WriteRaw(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	// Across the hour buckets.
+	q := mm.Query{Name: "mysql/questions", Instance: "db1", From: time.Unix(ts-60, 0), To: time.Unix(ts+60, 0)}
+	got, err := s.ReadRaw(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, samples[:2]) {
+		t.Errorf("got %+v, expected %+v", got, samples[:2])
+	}
+
+	q.Name, q.Instance = "mysql/*", ""
+	q.From = time.Unix(ts, 0)
+	if got, _ = s.ReadRaw(q); !reflect.DeepEqual(got, samples[1:]) {
+		t.Errorf("got %+v, expected %+v", got, samples[1:])
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s, cleanup := newTestSink(t)
+	defer cleanup()
+	s.config.RawRetentionHours = 2
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -s.config.Retention-1)
+	for _, ts := range []time.Time{old, now} {
+		report := &mm.Report{
+			Ts:       ts,
+			Duration: 60,
+			Stats: []*mm.InstanceStats{
+				{Instance: "db1", Stats: map[string]*mm.Stats{
+					"mysql/threads_running": {Name: "mysql/threads_running", Type: "gauge", Cnt: 1, Avg: 1},
+				}},
+			},
+			Events: []mm.Event{{Ts: ts.Unix(), Instance: "db1", Type: mm.EventRestart}},
+		}
+		// Pruned once a day, so forget the last prune.
+		s.pruned = ""
+		if err := s.Write("mm", report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samples := []mm.Sample{
+		{Ts: now.Add(-3 * time.Hour).Unix(), Instance: "db1", Name: "mysql/threads_running", Type: "gauge", Value: 1},
+		{Ts: now.Unix(), Instance: "db1", Name: "mysql/threads_running", Type: "gauge", Value: 1},
+	}
+	if err := s.WriteRaw(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		dataBucket + now.Format(dayFormat),
+		eventsBucket + now.Format(dayFormat),
+		namesBucket + now.Format(dayFormat),
+		rawBucket + now.Format(hourFormat),
+	}
+	sort.Strings(expect)
+	if got := buckets(t, s); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got buckets %v, expected %v", got, expect)
+	}
+}
+
+func newTestSink(t *testing.T) (*BoltSink, func()) {
+	dir, err := ioutil.TempDir("", "boltSink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.Path = filepath.Join(dir, "metrics.db")
+	s, err := NewBoltSink("bolt", config)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func buckets(t *testing.T, s *BoltSink) []string {
+	names := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// dump returns the series with their points, not their pointers.
+func dump(series []*mm.Series) string {
+	s := []string{}
+	for _, ser := range series {
+		for _, p := range ser.Points {
+			s = append(s, fmt.Sprintf("%s %s %+v", ser.Instance, ser.Name, *p))
+		}
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
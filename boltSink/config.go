@@ -0,0 +1,36 @@
+package boltSink
+
+import (
+	"fmt"
+)
+
+type Config struct {
+	Path              string // database file, created if it doesn't exist
+	Retention         int    // days of reports and events to keep
+	RawRetentionHours int    // hours of raw samples to keep if the sink has Raw, 0 = forever
+	KeepValues        bool   // store every value, not only the stats, for exact percentiles at lower resolutions
+	Timeout           int64  // seconds to wait for another process to release the file
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Path:              "metrics.db",
+		Retention:         7,
+		RawRetentionHours: 24,
+		Timeout:           10,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("Path is not set")
+	}
+	if c.Retention < 1 {
+		return fmt.Errorf("Invalid Retention: %d; must be >= 1 day", c.Retention)
+	}
+	if c.RawRetentionHours < 0 {
+		return fmt.Errorf("Invalid RawRetentionHours: %d; must be >= 0", c.RawRetentionHours)
+	}
+	return nil
+}
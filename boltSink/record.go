@@ -0,0 +1,196 @@
+package boltSink
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"../mm"
+)
+
+// A record is one metric of one report.  Its ts and instance are in the key.
+// Records are encoded as:
+//
+//	uvarint duration
+//	string  name
+//	uvarint labels, then string key, string value for each
+//	uvarint cnt
+//	float64 min, pct5, avg, med, pct95, max
+//	uvarint values, then float64 for each
+//
+// where a string is its uvarint length then its bytes and a float64 is its
+// 8 byte big-endian IEEE 754 bits.
+type record struct {
+	Duration uint
+	Name     string
+	Labels   map[string]string
+	Cnt      int
+	Min      float64
+	Pct5     float64
+	Avg      float64
+	Med      float64
+	Pct95    float64
+	Max      float64
+	Values   []float64
+}
+
+var errCorrupt = errors.New("corrupt record")
+
+func (r *record) encode() []byte {
+	buf := make([]byte, 0, 64+len(r.Name)+8*len(r.Values))
+	buf = appendUvarint(buf, uint64(r.Duration))
+	buf = appendString(buf, r.Name)
+	buf = appendUvarint(buf, uint64(len(r.Labels)))
+	for k, v := range r.Labels {
+		buf = appendString(buf, k)
+		buf = appendString(buf, v)
+	}
+	buf = appendUvarint(buf, uint64(r.Cnt))
+	for _, f := range []float64{r.Min, r.Pct5, r.Avg, r.Med, r.Pct95, r.Max} {
+		buf = appendFloat(buf, f)
+	}
+	buf = appendUvarint(buf, uint64(len(r.Values)))
+	for _, f := range r.Values {
+		buf = appendFloat(buf, f)
+	}
+	return buf
+}
+
+func decodeRecord(data []byte) (*record, error) {
+	d := &decoder{data: data}
+	r := &record{}
+	r.Duration = uint(d.uvarint())
+	r.Name = d.string()
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		r.Labels = make(map[string]string)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.string()
+			r.Labels[k] = d.string()
+		}
+	}
+	r.Cnt = int(d.uvarint())
+	r.Min = d.float()
+	r.Pct5 = d.float()
+	r.Avg = d.float()
+	r.Med = d.float()
+	r.Pct95 = d.float()
+	r.Max = d.float()
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		if n > uint64(len(d.data))/8 {
+			return nil, errCorrupt
+		}
+		r.Values = make([]float64, n)
+		for i := range r.Values {
+			r.Values[i] = d.float()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return r, nil
+}
+
+// A sample is encoded like a record:
+//
+//	string  name
+//	uvarint labels, then string key, string value for each
+//	string  type
+//	float64 value, rate
+//
+// Its ts and instance are in the key.
+func encodeSample(s *mm.Sample) []byte {
+	buf := make([]byte, 0, 32+len(s.Name))
+	buf = appendString(buf, s.Name)
+	buf = appendUvarint(buf, uint64(len(s.Labels)))
+	for k, v := range s.Labels {
+		buf = appendString(buf, k)
+		buf = appendString(buf, v)
+	}
+	buf = appendString(buf, s.Type)
+	buf = appendFloat(buf, s.Value)
+	return appendFloat(buf, s.Rate)
+}
+
+func decodeSample(data []byte) (*mm.Sample, error) {
+	d := &decoder{data: data}
+	s := &mm.Sample{}
+	s.Name = d.string()
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		s.Labels = make(map[string]string)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			k := d.string()
+			s.Labels[k] = d.string()
+		}
+	}
+	s.Type = d.string()
+	s.Value = d.float()
+	s.Rate = d.float()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return s, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(f))
+	return append(buf, b[:]...)
+}
+
+// A decoder reads the fields of a record in order.  After the first error,
+// every read returns the zero value and err is set.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errCorrupt
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errCorrupt
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 8 {
+		d.err = errCorrupt
+		return 0
+	}
+	f := math.Float64frombits(binary.BigEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return f
+}
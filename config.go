@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+
+	"./mm"
+	"./mysql"
+)
+
+type Config struct {
+	Interval    int64  // seconds between reports, default 60
+	Grace       int64  // seconds an interval stays open for late collections, default 5
+	QueueSize   int    // collections buffered between collectors and aggregator, default 100
+	QueuePolicy string // drop-oldest (default) or drop-newest when the queue is full
+	API         string // host:port for /healthz and /status, empty disables
+	Collectors  []mm.CollectorConfig
+
+	// Where reports are written, default mongo with its default config.
+	// Sink is used only if Sinks is empty; set Sinks to write to several.
+	Sink  mm.SinkConfig
+	Sinks []mm.SinkConfig
+
+	// Origin allowed to call the Grafana datasource from a browser, e.g.
+	// https://grafana.example.com; empty = none, use Grafana server access.
+	GrafanaOrigin string
+}
+
+const (
+	DefaultInterval  = 60
+	DefaultGrace     = 5
+	DefaultQueueSize = 100
+	DefaultAPI       = "127.0.0.1:9920"
+)
+
+// DefaultConfig collects MySQL metrics from localhost and, on Linux, OS
+// metrics.
+func DefaultConfig() *Config {
+	c := &Config{
+		Interval:    DefaultInterval,
+		Grace:       DefaultGrace,
+		QueueSize:   DefaultQueueSize,
+		QueuePolicy: mm.DropOldest,
+		API:         DefaultAPI,
+		Sink:        mm.SinkConfig{Type: "mongo"},
+		Collectors: []mm.CollectorConfig{
+			{Type: "mysql", Config: json.RawMessage(`{"DSN": "root@tcp(localhost:3306)/test"}`)},
+		},
+	}
+	if runtime.GOOS == "linux" {
+		// It reads /proc.
+		c.Collectors = append(c.Collectors, mm.CollectorConfig{Type: "os"})
+	}
+	return c
+}
+
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{
+		Interval:    DefaultInterval,
+		Grace:       DefaultGrace,
+		QueueSize:   DefaultQueueSize,
+		QueuePolicy: mm.DropOldest,
+		API:         DefaultAPI,
+		Sink:        mm.SinkConfig{Type: "mongo"},
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("Invalid Interval: %d", config.Interval)
+	}
+	if config.Grace < 0 || config.Grace >= config.Interval {
+		return nil, fmt.Errorf("Invalid Grace: %d; must be >= 0 and < Interval", config.Grace)
+	}
+	return config, nil
+}
+
+// SinkConfigs returns Sinks, or Sink if Sinks is empty.
+func (c *Config) SinkConfigs() []mm.SinkConfig {
+	if len(c.Sinks) > 0 {
+		return c.Sinks
+	}
+	return []mm.SinkConfig{c.Sink}
+}
+
+// Masked returns a copy of the config with DSN, URL and sink passwords hidden.
+func (c *Config) Masked() *Config {
+	masked := *c
+	masked.Sink.Config = maskConfig(c.Sink.Config)
+	masked.Sinks = make([]mm.SinkConfig, len(c.Sinks))
+	for i, sc := range c.Sinks {
+		masked.Sinks[i] = sc
+		masked.Sinks[i].Config = maskConfig(sc.Config)
+	}
+	masked.Collectors = make([]mm.CollectorConfig, len(c.Collectors))
+	for i, cc := range c.Collectors {
+		masked.Collectors[i] = cc
+		masked.Collectors[i].Config = maskConfig(cc.Config)
+	}
+	return &masked
+}
+
+// maskConfig returns the collector or sink config with the passwords of its
+// DSNs, URLs and Endpoints and its passwords, tokens and header values
+// hidden, or nil if it's invalid.  Nested objects, e.g. a TLS config or a
+// list of servers, are masked the same way.  Keys are matched
+// case-insensitively like json.Unmarshal matches fields.
+func maskConfig(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return data
+	}
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil
+	}
+	maskObject(config)
+	masked, _ := json.Marshal(config)
+	return masked
+}
+
+func maskObject(config map[string]interface{}) {
+	for key, value := range config {
+		switch strings.ToLower(key) {
+		case "dsn":
+			if dsn, ok := value.(string); ok {
+				config[key] = mysql.HideDSNPassword(dsn)
+			}
+		case "url", "endpoint":
+			if url, ok := value.(string); ok {
+				config[key] = mm.HideURLPassword(url)
+			}
+		case "password", "token", "bearertoken":
+			config[key] = "***"
+		case "headers":
+			// Any header can be a credential, e.g. Authorization.
+			if headers, ok := value.(map[string]interface{}); ok {
+				for name := range headers {
+					headers[name] = "***"
+				}
+			}
+		default:
+			maskNested(value)
+		}
+	}
+}
+
+// maskNested masks the objects in the value, which is an object or a list.
+func maskNested(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		maskObject(v)
+	case []interface{}:
+		for _, e := range v {
+			maskNested(e)
+		}
+	}
+}
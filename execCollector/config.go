@@ -0,0 +1,20 @@
+package execCollector
+
+type Config struct {
+	Commands []Command
+}
+
+type Command struct {
+	Name      string   // metric name prefix: exec/<name>/<metric>
+	Cmd       []string // command and args, run without a shell
+	Interval  int64    // seconds, default 60
+	Timeout   int64    // seconds, default 10
+	Format    string   // text (default) or json
+	MaxOutput int64    // bytes of stdout read, default 1 MiB; more is an error
+}
+
+const (
+	DefaultInterval  = 60
+	DefaultTimeout   = 10
+	DefaultMaxOutput = 1 << 20
+)
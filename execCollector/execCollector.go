@@ -0,0 +1,366 @@
+package execCollector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../mm"
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+)
+
+var errTimeout = errors.New("Timeout")
+
+// Bytes of stderr kept for the error message when a command fails.
+const maxStderr = 4096
+
+func init() {
+	mm.RegisterCollector("exec", func(name string, data []byte) (mm.Collector, error) {
+		config := &Config{}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if len(config.Commands) == 0 {
+			return nil, errors.New("No commands")
+		}
+		names := map[string]bool{}
+		for i := range config.Commands {
+			cmd := &config.Commands[i]
+			if cmd.Name == "" || len(cmd.Cmd) == 0 {
+				return nil, fmt.Errorf("Command %d: Name and Cmd are required", i)
+			}
+			if names[cmd.Name] {
+				return nil, fmt.Errorf("Duplicate command name: %s", cmd.Name)
+			}
+			names[cmd.Name] = true
+			if cmd.Interval <= 0 {
+				cmd.Interval = DefaultInterval
+			}
+			if cmd.Timeout <= 0 {
+				cmd.Timeout = DefaultTimeout
+			}
+			if cmd.MaxOutput <= 0 {
+				cmd.MaxOutput = DefaultMaxOutput
+			}
+			switch cmd.Format {
+			case "":
+				cmd.Format = "text"
+			case "text", "json":
+			default:
+				return nil, fmt.Errorf("Command %s: invalid format: %s", cmd.Name, cmd.Format)
+			}
+		}
+		return NewExecCollector(name, config), nil
+	})
+}
+
+// ExecCollector runs commands and collects the metrics they print.  Text
+// output is one "name type value [label=value ...]" metric per line, e.g.
+// "age gauge 3600 host=db1"; blank lines and lines starting with # are
+// ignored.  JSON output is a list of {"Name": "age", "Type": "gauge",
+// "Value": 3600, "Labels": {"host": "db1"}} objects.
+type ExecCollector struct {
+	name           string
+	config         *Config
+	tickChan       <-chan time.Time
+	collectionChan chan *mm.Collection
+	stopChan       chan bool
+	doneChan       chan bool
+	status         *pct.Status
+	running        map[string]bool // commands running now, keyed on name
+	runningMux     *sync.Mutex
+	wg             *sync.WaitGroup
+}
+
+func NewExecCollector(name string, config *Config) *ExecCollector {
+	procs := []string{name}
+	for _, cmd := range config.Commands {
+		procs = append(procs, name+"-"+cmd.Name)
+	}
+	e := &ExecCollector{
+		name:       name,
+		config:     config,
+		status:     pct.NewStatus(procs),
+		running:    make(map[string]bool),
+		runningMux: &sync.Mutex{},
+		wg:         &sync.WaitGroup{},
+	}
+	return e
+}
+
+func (e *ExecCollector) Name() string {
+	return e.name
+}
+
+func (e *ExecCollector) Start(tickChan <-chan time.Time, collectionChan chan *mm.Collection) error {
+	e.tickChan = tickChan
+	e.collectionChan = collectionChan
+	e.stopChan = make(chan bool)
+	e.doneChan = make(chan bool)
+	go e.run()
+
+	return nil
+}
+
+func (e *ExecCollector) Stop() error {
+	close(e.stopChan)
+	<-e.doneChan
+	return nil
+}
+
+func (e *ExecCollector) Status() map[string]string {
+	return e.status.All()
+}
+
+func (e *ExecCollector) run() {
+	log.Debug("run:call")
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Exec collector crashed: ", err)
+		}
+		// Wait for running commands; they're killed by their timeout at worst.
+		e.wg.Wait()
+		e.status.Update(e.name, "Stopped")
+		log.Debug("run:return")
+		close(e.doneChan)
+	}()
+
+	next := make([]int64, len(e.config.Commands))
+	e.status.Update(e.name, "Running")
+	for {
+		select {
+		case now := <-e.tickChan:
+			ts := now.UTC().Unix()
+			for i := range e.config.Commands {
+				cmd := e.config.Commands[i]
+				if ts < next[i] {
+					continue
+				}
+				next[i] = (ts/cmd.Interval + 1) * cmd.Interval
+
+				// Don't run a command again if it hasn't finished yet.
+				e.runningMux.Lock()
+				if e.running[cmd.Name] {
+					e.runningMux.Unlock()
+					log.Warn(fmt.Sprintf("Command %s still running, skipping this interval", cmd.Name))
+					continue
+				}
+				e.running[cmd.Name] = true
+				e.runningMux.Unlock()
+
+				e.wg.Add(1)
+				go e.collect(cmd, ts)
+			}
+		case <-e.stopChan:
+			return
+		}
+	}
+}
+
+// @goroutine[2]
+func (e *ExecCollector) collect(cmd Command, ts int64) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Command ", cmd.Name, " crashed: ", err)
+		}
+		e.runningMux.Lock()
+		delete(e.running, cmd.Name)
+		e.runningMux.Unlock()
+		e.wg.Done()
+	}()
+
+	proc := e.name + "-" + cmd.Name
+	e.status.Update(proc, "Running")
+
+	selfLabels := map[string]string{"collector": e.name, "source": cmd.Name}
+	t0 := time.Now()
+	out, err := e.exec(cmd)
+	mm.Self.Set("self/collect/seconds", selfLabels, time.Since(t0).Seconds())
+	if err != nil {
+		mm.Self.Inc("self/exec/errors", selfLabels)
+		log.Warn(fmt.Sprintf("Command %s failed: %s", cmd.Name, err))
+		e.status.Update(proc, "Failed: "+err.Error())
+		return
+	}
+
+	c := &mm.Collection{
+		Instance: e.name,
+		Ts:       ts,
+		Interval: cmd.Interval,
+		Metrics:  []mm.Metric{},
+	}
+	prefix := "exec/" + cmd.Name + "/"
+	switch cmd.Format {
+	case "json":
+		err = parseJSON(out, prefix, c)
+	default:
+		err = parseText(out, prefix, c)
+	}
+	if err != nil {
+		log.Warn(fmt.Sprintf("Cannot parse output of command %s: %s", cmd.Name, err))
+		e.status.Update(proc, "Invalid output: "+err.Error())
+		return
+	}
+	e.status.Update(proc, "Ok at "+time.Unix(ts, 0).UTC().Format(time.RFC3339))
+
+	if len(c.Metrics) == 0 {
+		log.Debug("collect:no " + cmd.Name + " metrics")
+		return
+	}
+	select {
+	case e.collectionChan <- c:
+	case <-time.After(500 * time.Millisecond):
+		// lost collection
+		log.Debug("Lost " + cmd.Name + " metrics; timeout spooling after 500ms")
+		mm.Self.Inc("self/collect/lost", selfLabels)
+	}
+}
+
+// exec runs the command and returns its stdout.  The command is killed if it
+// runs longer than its timeout or prints more than MaxOutput bytes.
+func (e *ExecCollector) exec(cmd Command) ([]byte, error) {
+	stderr := &headWriter{max: maxStderr}
+	c := exec.Command(cmd.Cmd[0], cmd.Cmd[1:]...)
+	c.Stderr = stderr
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	setProcessGroup(c)
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		out []byte
+		err error
+	}
+	doneChan := make(chan result, 1)
+	go func() {
+		// Read one byte more than MaxOutput to know if there's more.
+		out, err := ioutil.ReadAll(io.LimitReader(stdout, cmd.MaxOutput+1))
+		if err == nil && int64(len(out)) > cmd.MaxOutput {
+			// Don't leave it blocked writing the rest.
+			killProcessGroup(c)
+			c.Wait()
+			doneChan <- result{nil, fmt.Errorf("Output truncated: more than %d bytes; increase MaxOutput", cmd.MaxOutput)}
+			return
+		}
+		if werr := c.Wait(); werr != nil {
+			err = werr
+		}
+		doneChan <- result{out, err}
+	}()
+
+	select {
+	case r := <-doneChan:
+		if r.err != nil {
+			if stderr.buf.Len() > 0 {
+				return nil, fmt.Errorf("%s: %s", r.err, strings.TrimSpace(stderr.buf.String()))
+			}
+			return nil, r.err
+		}
+		return r.out, nil
+	case <-time.After(time.Duration(cmd.Timeout) * time.Second):
+		if err := killProcessGroup(c); err != nil {
+			log.Warn(fmt.Sprintf("Cannot kill command %s: %s", cmd.Name, err))
+		}
+		// Children that left the process group can keep stdout open, so
+		// don't wait forever.
+		select {
+		case <-doneChan:
+		case <-time.After(time.Second):
+		}
+		return nil, errTimeout
+	}
+}
+
+// A headWriter keeps the first max bytes written to it and discards the
+// rest, so a command can't grow it without limit.
+type headWriter struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := w.max - w.buf.Len(); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf.Write(p[:n])
+	}
+	return len(p), nil
+}
+
+func parseText(out []byte, prefix string, c *mm.Collection) error {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: expected \"name type value [label=value ...]\", got: %s", n, line)
+		}
+		if !mm.MetricTypes[fields[1]] {
+			return fmt.Errorf("line %d: invalid metric type: %s", n, fields[1])
+		}
+		val, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		// JSON can't have them either, and one would make the stats NaN.
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return fmt.Errorf("line %d: invalid value: %s", n, fields[2])
+		}
+		var labels map[string]string
+		for _, label := range fields[3:] {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return fmt.Errorf("line %d: invalid label: %s", n, label)
+			}
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[kv[0]] = kv[1]
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{prefix + fields[0], fields[1], val, "", labels})
+	}
+	return s.Err()
+}
+
+func parseJSON(out []byte, prefix string, c *mm.Collection) error {
+	metrics := []struct {
+		Name   string
+		Type   string
+		Value  float64
+		Labels map[string]string
+	}{}
+	if err := json.Unmarshal(out, &metrics); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if m.Name == "" {
+			return errors.New("metric without a name")
+		}
+		if !mm.MetricTypes[m.Type] {
+			return fmt.Errorf("%s: invalid metric type: %s", m.Name, m.Type)
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{prefix + m.Name, m.Type, m.Value, "", m.Labels})
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+//go:build !windows
+// +build !windows
+
+package execCollector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"../mm"
+)
+
+func TestExecMaxOutput(t *testing.T) {
+	e := NewExecCollector("exec", &Config{})
+	cmd := Command{
+		Name:      "big",
+		Cmd:       []string{"sh", "-c", "while :; do echo 'big gauge 1'; done"},
+		Timeout:   10,
+		MaxOutput: 1000,
+	}
+	if _, err := e.exec(cmd); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("got error %v, expected output truncated", err)
+	}
+
+	cmd.Cmd = []string{"sh", "-c", "echo 'small gauge 1'"}
+	out, err := e.exec(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "small gauge 1\n" {
+		t.Errorf("got %q", out)
+	}
+
+	cmd.Cmd = []string{"sh", "-c", "echo oops >&2; exit 1"}
+	if _, err := e.exec(cmd); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("got error %v, expected stderr in it", err)
+	}
+}
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		out     string
+		metrics []mm.Metric
+		err     string
+	}{
+		{
+			out: "# comment\n\nqueue_length gauge 3\n  jobs_done counter 1.5e3  \n",
+			metrics: []mm.Metric{
+				{"app/queue_length", "gauge", 3, "", nil},
+				{"app/jobs_done", "counter", 1500, "", nil},
+			},
+		},
+		{
+			out: "queue_length gauge -1 queue=high host=db1 empty=\n",
+			metrics: []mm.Metric{
+				{"app/queue_length", "gauge", -1, "", map[string]string{"queue": "high", "host": "db1", "empty": ""}},
+			},
+		},
+		{out: "", metrics: nil},
+		{out: "ok gauge 1\nqueue_length gauge\n", err: "line 2: expected"},
+		{out: "queue_length histogram 3\n", err: "line 1: invalid metric type: histogram"},
+		{out: "queue_length gauge three\n", err: "line 1: strconv.ParseFloat"},
+		{out: "queue_length gauge NaN\n", err: "line 1: invalid value: NaN"},
+		{out: "queue_length gauge -Inf\n", err: "line 1: invalid value: -Inf"},
+		{out: "queue_length gauge 3 queue\n", err: "line 1: invalid label: queue"},
+		{out: "queue_length gauge 3 =high\n", err: "line 1: invalid label: =high"},
+	}
+	for _, test := range tests {
+		c := &mm.Collection{}
+		err := parseText([]byte(test.out), "app/", c)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%q: got error %v, expected %s", test.out, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.out, err)
+		}
+		if !reflect.DeepEqual(c.Metrics, test.metrics) {
+			t.Errorf("%q: got %+v, expected %+v", test.out, c.Metrics, test.metrics)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		out     string
+		metrics []mm.Metric
+		err     string
+	}{
+		{
+			out: `[{"Name": "queue_length", "Type": "gauge", "Value": 3, "Labels": {"queue": "high"}}, {"name": "jobs_done", "type": "counter", "value": 1.5e3}]`,
+			metrics: []mm.Metric{
+				{"app/queue_length", "gauge", 3, "", map[string]string{"queue": "high"}},
+				{"app/jobs_done", "counter", 1500, "", nil},
+			},
+		},
+		{out: `[]`, metrics: nil},
+		{out: `[{"Type": "gauge", "Value": 1}]`, err: "metric without a name"},
+		{out: `[{"Name": "queue_length", "Value": 1}]`, err: "queue_length: invalid metric type: "},
+		{out: `[{"Name": "queue_length", "Type": "gauge", "Value": "1"}]`, err: "json: cannot unmarshal string"},
+		{out: `[{"Name": "queue_length", "Type": "gauge", "Value": NaN}]`, err: "invalid character 'N'"},
+		{out: `{"Name": "queue_length", "Type": "gauge", "Value": 1}`, err: "json: cannot unmarshal object"},
+		{out: `[{"Name": "queue_length", "Type": "gauge", "Value": 1}`, err: "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		c := &mm.Collection{}
+		err := parseJSON([]byte(test.out), "app/", c)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, expected %s", test.out, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.out, err)
+		}
+		if !reflect.DeepEqual(c.Metrics, test.metrics) {
+			t.Errorf("%s: got %+v, expected %+v", test.out, c.Metrics, test.metrics)
+		}
+	}
+}
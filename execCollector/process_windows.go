@@ -0,0 +1,13 @@
+package execCollector
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(c *exec.Cmd) {
+}
+
+// killProcessGroup kills only the command; its children keep running.
+func killProcessGroup(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
@@ -0,0 +1,65 @@
+package fileSink
+
+import (
+	"fmt"
+)
+
+const (
+	JSONLines = "jsonl"
+	CSV       = "csv"
+)
+
+type Config struct {
+	Path      string // file written to; rotated files are Path.20060102-150405[.gz]
+	Format    string // jsonl or csv
+	MaxSize   int64  // MiB before rotating, 0 = no limit
+	MaxAge    int64  // seconds per file, aligned to the epoch so 86400 rotates at 00:00 UTC, 0 = no limit
+	Gzip      bool   // compress rotated files
+	Retention int    // days to keep rotated files, 0 = forever
+	MaxFiles  int    // rotated files to keep, 0 = no limit
+
+	// Raw samples if the sink has Raw, rotated like Path.
+	RawPath           string // default Path with -raw before the extension, e.g. metrics-raw.jsonl
+	RawRetentionHours int    // hours to keep rotated raw files, 0 = forever
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Path:      "metrics.jsonl",
+		Format:    JSONLines,
+		MaxSize:   100,
+		MaxAge:    86400,
+		Gzip:      true,
+		Retention: 7,
+		// --
+		RawRetentionHours: 24,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	switch c.Format {
+	case JSONLines, CSV:
+	default:
+		return fmt.Errorf("Invalid Format: %s; expected jsonl or csv", c.Format)
+	}
+	if c.Path == "" {
+		return fmt.Errorf("Path is not set")
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("Invalid MaxSize: %d; must be >= 0", c.MaxSize)
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("Invalid MaxAge: %d; must be >= 0", c.MaxAge)
+	}
+	if c.Retention < 0 {
+		return fmt.Errorf("Invalid Retention: %d; must be >= 0", c.Retention)
+	}
+	if c.RawRetentionHours < 0 {
+		return fmt.Errorf("Invalid RawRetentionHours: %d; must be >= 0", c.RawRetentionHours)
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("Invalid MaxFiles: %d; must be >= 0", c.MaxFiles)
+	}
+	return nil
+}
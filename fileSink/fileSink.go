@@ -0,0 +1,513 @@
+package fileSink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	mm.RegisterSink("file", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewFileSink(name, config), nil
+	})
+}
+
+const rotatedFormat = "20060102-150405"
+
+var (
+	csvHeader    = []string{"ts", "duration", "instance", "name", "labels", "type", "cnt", "min", "pct5", "avg", "med", "pct95", "max"}
+	csvRawHeader = []string{"ts", "instance", "name", "labels", "type", "value", "rate"}
+)
+
+// FileSink appends one row per instance, metric and report to a file as
+// JSON Lines or CSV.  JSON Lines also has a row per event; CSV has no events.
+// With Raw, it also appends one row per sample to RawPath.  Files are rotated
+// by size and age, and rotated files are compressed and removed per the
+// config.
+type FileSink struct {
+	name       string
+	config     *Config
+	reports    *rotatingFile
+	raw        *rotatingFile
+	selfLabels map[string]string
+}
+
+func NewFileSink(name string, config *Config) *FileSink {
+	reportsHeader, rawHeader := csvHeader, csvRawHeader
+	if config.Format != CSV {
+		reportsHeader, rawHeader = nil, nil
+	}
+	rawPath := config.RawPath
+	if rawPath == "" {
+		ext := filepath.Ext(config.Path)
+		rawPath = strings.TrimSuffix(config.Path, ext) + "-raw" + ext
+	}
+	s := &FileSink{
+		name:   name,
+		config: config,
+		reports: &rotatingFile{
+			path:      config.Path,
+			header:    reportsHeader,
+			retention: time.Duration(config.Retention) * 24 * time.Hour,
+			config:    config,
+		},
+		raw: &rotatingFile{
+			path:      rawPath,
+			header:    rawHeader,
+			retention: time.Duration(config.RawRetentionHours) * time.Hour,
+			config:    config,
+		},
+		selfLabels: map[string]string{"sink": name},
+	}
+	return s
+}
+
+func (s *FileSink) Write(service string, report *mm.Report) error {
+	log.Debug("FileSink.Write:call")
+	defer log.Debug("FileSink.Write:return")
+
+	var rows []byte
+	if s.config.Format == CSV {
+		rows = csvRows(report)
+	} else {
+		rows = jsonRows(report)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+	if err := s.reports.write(rows); err != nil {
+		mm.Self.Inc("self/sink/errors", s.selfLabels)
+		return err
+	}
+	return nil
+}
+
+func (s *FileSink) WriteRaw(samples []mm.Sample) error {
+	var rows []byte
+	if s.config.Format == CSV {
+		rows = csvRawRows(samples)
+	} else {
+		rows = jsonRawRows(samples)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+	if err := s.raw.write(rows); err != nil {
+		mm.Self.Inc("self/sink/errors", s.selfLabels)
+		return err
+	}
+	return nil
+}
+
+func (s *FileSink) Close() {
+	s.reports.close()
+	s.raw.close()
+}
+
+// --------------------------------------------------------------------------
+
+// A rotatingFile is a file that's appended to and rotated per the config.
+type rotatingFile struct {
+	path      string
+	header    []string      // CSV header written first in every file, if any
+	retention time.Duration // how long to keep rotated files, 0 = forever
+	config    *Config
+	file      *os.File
+	size      int64 // bytes in file
+	period    int64 // MaxAge period of the last write
+}
+
+// write opens the file if needed, rotates it if it's too big or old, then
+// appends the rows.  If writing fails, the file is closed so it's reopened
+// next time in case it was moved or removed.  Rows written before the
+// failure are removed, so a retry doesn't append them after a torn row.
+func (f *rotatingFile) write(rows []byte) error {
+	err := f.append(rows)
+	if err != nil && f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return err
+}
+
+func (f *rotatingFile) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+func (f *rotatingFile) append(rows []byte) error {
+	now := time.Now()
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && (f.config.MaxSize > 0 && f.size+int64(len(rows)) > f.config.MaxSize<<20 ||
+		f.config.MaxAge > 0 && now.Unix()/f.config.MaxAge != f.period) {
+		if err := f.rotate(now); err != nil {
+			return err
+		}
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(rows)
+	if err != nil {
+		if n > 0 {
+			f.unwrite(n, now)
+		}
+		return err
+	}
+	f.size += int64(n)
+	if f.config.MaxAge > 0 {
+		f.period = now.Unix() / f.config.MaxAge
+	}
+	return nil
+}
+
+// unwrite truncates the n bytes of a failed write.  If it can't, the file is
+// rotated so the next write starts a new file.
+func (f *rotatingFile) unwrite(n int, now time.Time) {
+	err := f.file.Truncate(f.size)
+	if err == nil {
+		return
+	}
+	log.Warn(fmt.Sprintf("Cannot truncate %d bytes of a failed write to %s: %s", n, f.path, err))
+	if err := f.rotate(now); err != nil {
+		log.Warn(fmt.Sprintf("Cannot rotate %s: %s", f.path, err))
+	}
+}
+
+// open opens or creates the file for appending.  An existing file is
+// continued: its age is that of its last write.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = fi.Size()
+	if f.config.MaxAge > 0 {
+		f.period = fi.ModTime().Unix() / f.config.MaxAge
+	}
+	if f.header != nil && f.size == 0 {
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(f.header)
+		w.Flush()
+		n, err := f.file.Write(buf.Bytes())
+		f.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate closes the file, renames it path.<now>, compresses it if Gzip, and
+// removes old rotated files.  A failure to compress or remove is logged, not
+// returned, so it doesn't stop writing.
+func (f *rotatingFile) rotate(now time.Time) error {
+	f.file.Close()
+	f.file = nil
+
+	rotated := f.path + "." + now.UTC().Format(rotatedFormat)
+	if _, err := os.Stat(rotated); err == nil {
+		rotated += fmt.Sprintf(".%d", now.UnixNano())
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf("Rotated %s to %s", f.path, rotated))
+
+	if f.config.Gzip {
+		if err := compress(rotated); err != nil {
+			log.Warn(fmt.Sprintf("Cannot compress %s: %s", rotated, err))
+		}
+	}
+	f.removeOld(now)
+	return nil
+}
+
+// removeOld removes the rotated files older than retention and all but
+// the newest MaxFiles.
+func (f *rotatingFile) removeOld(now time.Time) {
+	if f.retention == 0 && f.config.MaxFiles == 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	type rotatedFile struct {
+		path string
+		ts   time.Time
+	}
+	files := []rotatedFile{}
+	for _, path := range matches {
+		suffix := strings.TrimPrefix(path, f.path+".")
+		if len(suffix) < len(rotatedFormat) {
+			continue
+		}
+		ts, err := time.Parse(rotatedFormat, suffix[:len(rotatedFormat)])
+		if err != nil {
+			continue // not ours
+		}
+		files = append(files, rotatedFile{path, ts})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+
+	cutoff := now.Add(-f.retention)
+	for i, rf := range files {
+		tooMany := f.config.MaxFiles > 0 && i < len(files)-f.config.MaxFiles
+		tooOld := f.retention > 0 && rf.ts.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		log.Info(fmt.Sprintf("Removing %s", rf.path))
+		if err := os.Remove(rf.path); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// compress gzips path to path.gz and removes path.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// --------------------------------------------------------------------------
+
+// number is a float64 that encodes NaN and ±Inf as JSON null.
+type number float64
+
+func (n number) MarshalJSON() ([]byte, error) {
+	f := float64(n)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+type metricRow struct {
+	Ts       time.Time
+	Duration uint
+	Instance string
+	Name     string
+	Labels   map[string]string `json:",omitempty"`
+	Type     string
+	Cnt      int
+	Min      number
+	Pct5     number
+	Avg      number
+	Med      number
+	Pct95    number
+	Max      number
+	Carried  bool `json:",omitempty"`
+}
+
+type eventRow struct {
+	Ts       time.Time
+	Instance string
+	Event    string
+	Text     string
+}
+
+func jsonRows(report *mm.Report) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf) // adds a newline after each row
+	forEachStats(report, func(instance string, stats *mm.Stats) {
+		enc.Encode(metricRow{
+			Ts:       report.Ts.UTC(),
+			Duration: report.Duration,
+			Instance: instance,
+			Name:     stats.Name,
+			Labels:   stats.Labels,
+			Type:     stats.Type,
+			Cnt:      stats.Cnt,
+			Min:      number(stats.Min),
+			Pct5:     number(stats.Pct5),
+			Avg:      number(stats.Avg),
+			Med:      number(stats.Med),
+			Pct95:    number(stats.Pct95),
+			Max:      number(stats.Max),
+			Carried:  stats.Carried,
+		})
+	})
+	for _, e := range report.Events {
+		enc.Encode(eventRow{
+			Ts:       time.Unix(e.Ts, 0).UTC(),
+			Instance: e.Instance,
+			Event:    e.Type,
+			Text:     e.Text,
+		})
+	}
+	return buf.Bytes()
+}
+
+func csvRows(report *mm.Report) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	ts := report.Ts.UTC().Format(time.RFC3339)
+	duration := strconv.FormatUint(uint64(report.Duration), 10)
+	forEachStats(report, func(instance string, stats *mm.Stats) {
+		w.Write([]string{
+			ts,
+			duration,
+			instance,
+			stats.Name,
+			labelString(stats.Labels),
+			stats.Type,
+			strconv.Itoa(stats.Cnt),
+			formatFloat(stats.Min),
+			formatFloat(stats.Pct5),
+			formatFloat(stats.Avg),
+			formatFloat(stats.Med),
+			formatFloat(stats.Pct95),
+			formatFloat(stats.Max),
+		})
+	})
+	w.Flush()
+	return buf.Bytes()
+}
+
+type sampleRow struct {
+	Ts       time.Time
+	Instance string
+	Name     string
+	Labels   map[string]string `json:",omitempty"`
+	Type     string
+	Value    number
+	Rate     *number `json:",omitempty"`
+}
+
+func jsonRawRows(samples []mm.Sample) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, sample := range samples {
+		row := sampleRow{
+			Ts:       time.Unix(sample.Ts, 0).UTC(),
+			Instance: sample.Instance,
+			Name:     sample.Name,
+			Labels:   sample.Labels,
+			Type:     sample.Type,
+			Value:    number(sample.Value),
+		}
+		if sample.Type == "counter" && !math.IsNaN(sample.Rate) {
+			rate := number(sample.Rate)
+			row.Rate = &rate
+		}
+		enc.Encode(row)
+	}
+	return buf.Bytes()
+}
+
+func csvRawRows(samples []mm.Sample) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for _, sample := range samples {
+		rate := ""
+		if sample.Type == "counter" && !math.IsNaN(sample.Rate) {
+			rate = formatFloat(sample.Rate)
+		}
+		w.Write([]string{
+			time.Unix(sample.Ts, 0).UTC().Format(time.RFC3339),
+			sample.Instance,
+			sample.Name,
+			labelString(sample.Labels),
+			sample.Type,
+			formatFloat(sample.Value),
+			rate,
+		})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// forEachStats calls fn for every stats of the report, in report instance
+// order then metric key order.
+func forEachStats(report *mm.Report, fn func(instance string, stats *mm.Stats)) {
+	for _, is := range report.Stats {
+		keys := make([]string, 0, len(is.Stats))
+		for k := range is.Stats {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fn(is.Instance, is.Stats[k])
+		}
+	}
+}
+
+// labelString returns "k1=v1;k2=v2" sorted by key.
+func labelString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return strings.Join(pairs, ";")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,131 @@
+package fileSink
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+func TestRows(t *testing.T) {
+	report := &mm.Report{
+		Ts:       time.Unix(1600000000, 0),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				Instance: "db1",
+				Stats: map[string]*mm.Stats{
+					"mysql/threads_running": {Name: "mysql/threads_running", Type: "gauge", Cnt: 2, Min: 1, Pct5: 1, Avg: 1.5, Med: 2, Pct95: 2, Max: math.NaN()},
+					// Collected every 300s, carried forward from the last interval.
+					"mysql/table/rows": {Name: "mysql/table/rows", Labels: map[string]string{"schema": "db", "table": "t"}, Type: "gauge", Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100, Carried: true},
+				},
+			},
+		},
+		Events: []mm.Event{{Ts: 1599999990, Instance: "db1", Type: mm.EventRestart, Text: "restarted"}},
+	}
+
+	expect := `{"Ts":"2020-09-13T12:26:40Z","Duration":60,"Instance":"db1","Name":"mysql/table/rows","Labels":{"schema":"db","table":"t"},"Type":"gauge","Cnt":0,"Min":100,"Pct5":100,"Avg":100,"Med":100,"Pct95":100,"Max":100,"Carried":true}
+{"Ts":"2020-09-13T12:26:40Z","Duration":60,"Instance":"db1","Name":"mysql/threads_running","Type":"gauge","Cnt":2,"Min":1,"Pct5":1,"Avg":1.5,"Med":2,"Pct95":2,"Max":null}
+{"Ts":"2020-09-13T12:26:30Z","Instance":"db1","Event":"restart","Text":"restarted"}
+`
+	if got := string(jsonRows(report)); got != expect {
+		t.Errorf("JSON got:\n%s\nexpected:\n%s", got, expect)
+	}
+
+	expect = "2020-09-13T12:26:40Z,60,db1,mysql/table/rows,schema=db;table=t,gauge,0,100,100,100,100,100,100\n" +
+		"2020-09-13T12:26:40Z,60,db1,mysql/threads_running,,gauge,2,1,1,1.5,2,2,NaN\n"
+	if got := string(csvRows(report)); got != expect {
+		t.Errorf("CSV got:\n%s\nexpected:\n%s", got, expect)
+	}
+}
+
+func TestRemoveOld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileSink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "metrics-raw.jsonl")
+	for _, name := range []string{
+		"metrics-raw.jsonl",
+		"metrics-raw.jsonl.20200913-030000.gz", // 9 hours old
+		"metrics-raw.jsonl.20200913-080000.gz", // 4 hours old
+		"metrics-raw.jsonl.20200913-110000",    // 1 hour old
+		"metrics-raw.jsonl.old",                // not rotated by us
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.Path = filepath.Join(dir, "metrics.jsonl")
+	config.RawRetentionHours = 6
+	s := NewFileSink("file", config)
+	if s.raw.path != path {
+		t.Fatalf("raw path %s, expected %s", s.raw.path, path)
+	}
+	s.raw.removeOld(now)
+	expect := []string{
+		"metrics-raw.jsonl",
+		"metrics-raw.jsonl.20200913-080000.gz",
+		"metrics-raw.jsonl.20200913-110000",
+		"metrics-raw.jsonl.old",
+	}
+	if got := files(t, dir); !equal(got, expect) {
+		t.Errorf("got %v, expected %v", got, expect)
+	}
+
+	// MaxFiles keeps the newest.
+	config.MaxFiles = 1
+	s.raw.removeOld(now)
+	expect = []string{
+		"metrics-raw.jsonl",
+		"metrics-raw.jsonl.20200913-110000",
+		"metrics-raw.jsonl.old",
+	}
+	if got := files(t, dir); !equal(got, expect) {
+		t.Errorf("got %v, expected %v", got, expect)
+	}
+
+	// 0 = forever.
+	config.MaxFiles = 0
+	config.RawRetentionHours = 0
+	s = NewFileSink("file", config)
+	s.raw.removeOld(now.AddDate(1, 0, 0))
+	if got := files(t, dir); !equal(got, expect) {
+		t.Errorf("got %v, expected %v", got, expect)
+	}
+}
+
+func files(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package graphiteSink
+
+import (
+	"fmt"
+
+	"../mm"
+)
+
+// Protocols
+const (
+	Plaintext = "plaintext"
+	Pickle    = "pickle"
+)
+
+type Config struct {
+	Addr     string   // Carbon host:port, usually :2003 for plaintext and :2004 for pickle
+	Network  string   // tcp or udp; pickle is tcp only
+	Protocol string   // plaintext or pickle
+	Template string   // path prefix; {instance} and {name} are replaced
+	Stats    []string // stats to send: cnt, min, pct5, avg, med, pct95, max
+	Timeout  int64    // seconds to connect and to write
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Addr:     "localhost:2003",
+		Network:  "tcp",
+		Protocol: Plaintext,
+		Template: "mm.{instance}.{name}",
+		Stats:    []string{"cnt", "min", "pct5", "avg", "med", "pct95", "max"},
+		Timeout:  10,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	switch c.Network {
+	case "tcp", "udp":
+	default:
+		return fmt.Errorf("Invalid Network: %s; expected tcp or udp", c.Network)
+	}
+	switch c.Protocol {
+	case Plaintext:
+	case Pickle:
+		if c.Network != "tcp" {
+			return fmt.Errorf("The pickle protocol requires tcp")
+		}
+	default:
+		return fmt.Errorf("Invalid Protocol: %s; expected %s or %s", c.Protocol, Plaintext, Pickle)
+	}
+	for _, stat := range c.Stats {
+		if _, ok := (&mm.Stats{}).Stat(stat); !ok {
+			return fmt.Errorf("Invalid stat: %s", stat)
+		}
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("Invalid Timeout: %d", c.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package graphiteSink
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"../mm"
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	mm.RegisterSink("graphite", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewGraphiteSink(name, config), nil
+	})
+}
+
+const (
+	maxDatagram    = 1400 // bytes per UDP packet, to avoid fragmentation
+	maxPickleBatch = 500  // datapoints per pickle message
+)
+
+type datapoint struct {
+	path  string
+	value float64
+	ts    int64
+}
+
+// GraphiteSink sends the stats of every report to Carbon, reconnecting with
+// a backoff when the connection fails.  It doesn't queue reports: the
+// mm.Dispatcher queues and retries them.
+type GraphiteSink struct {
+	name       string
+	config     *Config
+	backoff    *pct.Backoff
+	conn       net.Conn
+	mux        *sync.Mutex // guards conn
+	stopChan   chan bool
+	stopOnce   *sync.Once // Close may be called more than once
+	selfLabels map[string]string
+}
+
+func NewGraphiteSink(name string, config *Config) *GraphiteSink {
+	s := &GraphiteSink{
+		name:       name,
+		config:     config,
+		backoff:    pct.NewBackoff(20 * time.Second),
+		mux:        &sync.Mutex{},
+		stopChan:   make(chan bool),
+		stopOnce:   &sync.Once{},
+		selfLabels: map[string]string{"sink": name},
+	}
+	return s
+}
+
+func (s *GraphiteSink) Write(service string, report *mm.Report) error {
+	log.Debug("GraphiteSink.Write:call")
+	defer log.Debug("GraphiteSink.Write:return")
+
+	points := s.datapoints(report)
+	if len(points) == 0 {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.send(points); err != nil {
+		mm.Self.Inc("self/sink/errors", s.selfLabels)
+		if s.conn != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+		return fmt.Errorf("Cannot send to Carbon %s: %s", s.config.Addr, err)
+	}
+	return nil
+}
+
+// Close interrupts a Write waiting to reconnect and closes the connection.
+func (s *GraphiteSink) Close() {
+	s.stopOnce.Do(func() { close(s.stopChan) })
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *GraphiteSink) send(points []datapoint) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+
+	timeout := time.Duration(s.config.Timeout) * time.Second
+	for _, msg := range s.messages(points) {
+		s.conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := s.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connect dials Carbon after the backoff wait.  It returns an error if the
+// wait is interrupted by Close.
+func (s *GraphiteSink) connect() error {
+	select {
+	case <-time.After(s.backoff.Wait()):
+	case <-s.stopChan:
+		return fmt.Errorf("Stopped")
+	}
+	mm.Self.Set("self/sink/backoff_seconds", s.selfLabels, s.backoff.Last().Seconds())
+	conn, err := net.DialTimeout(s.config.Network, s.config.Addr, time.Duration(s.config.Timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	log.Info("Connected to Carbon ", s.config.Addr)
+	s.conn = conn
+	s.backoff.Success()
+	return nil
+}
+
+// messages returns the datapoints as Carbon messages: pickle batches, or
+// plaintext lines in datagram-sized chunks for UDP and one chunk for TCP.
+func (s *GraphiteSink) messages(points []datapoint) [][]byte {
+	msgs := [][]byte{}
+	if s.config.Protocol == Pickle {
+		for len(points) > 0 {
+			n := maxPickleBatch
+			if n > len(points) {
+				n = len(points)
+			}
+			msgs = append(msgs, pickle(points[:n]))
+			points = points[n:]
+		}
+		return msgs
+	}
+
+	var msg []byte
+	for _, dp := range points {
+		line := dp.path + " " + strconv.FormatFloat(dp.value, 'f', -1, 64) + " " + strconv.FormatInt(dp.ts, 10) + "\n"
+		if s.config.Network == "udp" && len(msg) > 0 && len(msg)+len(line) > maxDatagram {
+			msgs = append(msgs, msg)
+			msg = nil
+		}
+		msg = append(msg, line...)
+	}
+	if len(msg) > 0 {
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// --------------------------------------------------------------------------
+// Paths
+// --------------------------------------------------------------------------
+
+// datapoints returns a datapoint for every configured stat of every metric,
+// with paths like mm.db1.mysql.table.rows.schema.db1.table.t1.avg.
+func (s *GraphiteSink) datapoints(report *mm.Report) []datapoint {
+	points := []datapoint{}
+	ts := report.Ts.Unix()
+	for _, is := range report.Stats {
+		for _, stats := range is.Stats {
+			prefix := strings.NewReplacer(
+				"{instance}", pathNode(is.Instance),
+				"{name}", metricPath(stats.Name),
+			).Replace(s.config.Template)
+			prefix += labelPath(stats.Labels)
+			for _, stat := range s.config.Stats {
+				value, _ := stats.Stat(stat)
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					continue
+				}
+				points = append(points, datapoint{prefix + "." + stat, value, ts})
+			}
+		}
+	}
+	return points
+}
+
+// metricPath makes a metric name a path, e.g. mysql/innodb/x to mysql.innodb.x.
+func metricPath(name string) string {
+	nodes := strings.Split(name, "/")
+	for i := range nodes {
+		nodes[i] = pathNode(nodes[i])
+	}
+	return strings.Join(nodes, ".")
+}
+
+// labelPath returns ".key.value" for every label, sorted by key.
+func labelPath(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	path := ""
+	for _, k := range keys {
+		path += "." + pathNode(k) + "." + pathNode(labels[k])
+	}
+	return path
+}
+
+// pathNode makes s usable as one node of a path: dots would make more nodes
+// and whitespace would break the plaintext protocol.
+func pathNode(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '\t', '\n', '\r', '/':
+			return '_'
+		}
+		return r
+	}, s)
+}
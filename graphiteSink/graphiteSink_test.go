@@ -0,0 +1,11 @@
+package graphiteSink
+
+import (
+	"testing"
+)
+
+func TestCloseTwice(t *testing.T) {
+	s := NewGraphiteSink("graphite", DefaultConfig())
+	s.Close()
+	s.Close()
+}
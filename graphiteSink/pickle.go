@@ -0,0 +1,46 @@
+package graphiteSink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Pickle opcodes, protocol 2.  Only what's needed to pickle a list of
+// (path, (timestamp, value)) tuples, which is what Carbon's pickle receiver
+// expects.
+const (
+	opProto      = 0x80
+	opEmptyList  = ']'
+	opMark       = '('
+	opAppends    = 'e'
+	opBinUnicode = 'X'
+	opBinFloat   = 'G'
+	opTuple2     = 0x86
+	opStop       = '.'
+)
+
+// pickle returns the datapoints as a Carbon pickle message: a 4-byte
+// big-endian length header followed by the pickled list.
+func pickle(points []datapoint) []byte {
+	var p bytes.Buffer
+	p.Write([]byte{opProto, 2, opEmptyList, opMark})
+	for _, dp := range points {
+		p.WriteByte(opBinUnicode)
+		binary.Write(&p, binary.LittleEndian, uint32(len(dp.path)))
+		p.WriteString(dp.path)
+		pickleFloat(&p, float64(dp.ts))
+		pickleFloat(&p, dp.value)
+		p.Write([]byte{opTuple2, opTuple2})
+	}
+	p.Write([]byte{opAppends, opStop})
+
+	msg := make([]byte, 4, 4+p.Len())
+	binary.BigEndian.PutUint32(msg, uint32(p.Len()))
+	return append(msg, p.Bytes()...)
+}
+
+func pickleFloat(p *bytes.Buffer, f float64) {
+	p.WriteByte(opBinFloat)
+	binary.Write(p, binary.BigEndian, math.Float64bits(f))
+}
@@ -0,0 +1,60 @@
+package graphiteSink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// pickleGolden is what Python's pickle.loads decodes to
+// [('mm.db1.threads.avg', (1600000000.0, 1.5)), ('mm.db1.threads.max', (1600000000.0, -2.0))],
+// i.e. what Carbon's pickle receiver expects, after a 4-byte length header.
+var pickleGolden = []byte("" +
+	"\x00\x00\x00\x5c" + // length: 92 bytes
+	"\x80\x02" + // PROTO 2
+	"]" + // EMPTY_LIST
+	"(" + // MARK
+	"X\x12\x00\x00\x00mm.db1.threads.avg" + // BINUNICODE, little-endian length
+	"G\x41\xd7\xd7\x84\x00\x00\x00\x00" + // BINFLOAT 1600000000
+	"G\x3f\xf8\x00\x00\x00\x00\x00\x00" + // BINFLOAT 1.5
+	"\x86\x86" + // TUPLE2 TUPLE2
+	"X\x12\x00\x00\x00mm.db1.threads.max" +
+	"G\x41\xd7\xd7\x84\x00\x00\x00\x00" +
+	"G\xc0\x00\x00\x00\x00\x00\x00\x00" + // BINFLOAT -2
+	"\x86\x86" +
+	"e" + // APPENDS
+	".") // STOP
+
+func TestPickle(t *testing.T) {
+	points := []datapoint{
+		{"mm.db1.threads.avg", 1.5, 1600000000},
+		{"mm.db1.threads.max", -2, 1600000000},
+	}
+	got := pickle(points)
+	if !bytes.Equal(got, pickleGolden) {
+		t.Errorf("pickle:\n got %q\nwant %q", got, pickleGolden)
+	}
+}
+
+func TestPickleBatches(t *testing.T) {
+	config := DefaultConfig()
+	config.Protocol = Pickle
+	s := NewGraphiteSink("graphite", config)
+
+	points := make([]datapoint, maxPickleBatch+1)
+	for i := range points {
+		points[i] = datapoint{"mm.db1.threads.avg", float64(i), 1600000000}
+	}
+	msgs := s.messages(points)
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, expected 2", len(msgs))
+	}
+	for i, n := range []int{maxPickleBatch, 1} {
+		if !bytes.Equal(msgs[i], pickle(points[i*maxPickleBatch:i*maxPickleBatch+n])) {
+			t.Errorf("message %d isn't a pickle of %d points", i, n)
+		}
+		if l := binary.BigEndian.Uint32(msgs[i]); int(l) != len(msgs[i])-4 {
+			t.Errorf("message %d: length header %d, body %d bytes", i, l, len(msgs[i])-4)
+		}
+	}
+}
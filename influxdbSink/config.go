@@ -0,0 +1,63 @@
+package influxdbSink
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Config struct {
+	URL     string // http://host:port
+	Version int    // 1 = /write, 2 = /api/v2/write
+
+	// InfluxDB 1.x
+	Database        string
+	RetentionPolicy string // empty = default
+	Username        string
+	Password        string
+
+	// InfluxDB 2.x
+	Org    string
+	Bucket string
+	Token  string
+
+	BatchSize int   // lines per request
+	Gzip      bool  // compress requests
+	Timeout   int64 // seconds per request
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		URL:       "http://localhost:8086",
+		Version:   1,
+		Database:  "metrics",
+		BatchSize: 5000,
+		Gzip:      true,
+		Timeout:   10,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	if c.URL == "" {
+		return errors.New("URL is not set")
+	}
+	switch c.Version {
+	case 1:
+		if c.Database == "" {
+			return errors.New("Database is not set")
+		}
+	case 2:
+		if c.Org == "" || c.Bucket == "" {
+			return errors.New("Org and Bucket must be set for version 2")
+		}
+	default:
+		return fmt.Errorf("Invalid Version: %d; expected 1 or 2", c.Version)
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("Invalid BatchSize: %d", c.BatchSize)
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("Invalid Timeout: %d", c.Timeout)
+	}
+	return nil
+}
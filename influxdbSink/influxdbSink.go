@@ -0,0 +1,244 @@
+package influxdbSink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	mm.RegisterSink("influxdb", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewInfluxDBSink(name, config), nil
+	})
+}
+
+// Measurement of events; their instance and type are tags.
+const eventMeasurement = "events"
+
+// InfluxDBSink writes reports as InfluxDB line protocol.  Every metric is a
+// measurement with a field for each stat, tagged with the instance and the
+// metric labels:
+//
+//	mysql/threads_running,instance=db1 cnt=60i,min=1,pct5=1,avg=2.5,med=2,pct95=4,max=5 1600000000
+//	events,instance=db1,type=restart text="MySQL restarted (uptime was 86400s)" 1599999000
+type InfluxDBSink struct {
+	name       string
+	config     *Config
+	writeURL   string
+	client     *http.Client
+	selfLabels map[string]string
+}
+
+func NewInfluxDBSink(name string, config *Config) *InfluxDBSink {
+	s := &InfluxDBSink{
+		name:       name,
+		config:     config,
+		writeURL:   writeURL(config),
+		client:     &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		selfLabels: map[string]string{"sink": name},
+	}
+	return s
+}
+
+func writeURL(config *Config) string {
+	params := url.Values{}
+	params.Set("precision", "s")
+	base := strings.TrimRight(config.URL, "/")
+	if config.Version == 2 {
+		params.Set("org", config.Org)
+		params.Set("bucket", config.Bucket)
+		return base + "/api/v2/write?" + params.Encode()
+	}
+	params.Set("db", config.Database)
+	if config.RetentionPolicy != "" {
+		params.Set("rp", config.RetentionPolicy)
+	}
+	return base + "/write?" + params.Encode()
+}
+
+func (s *InfluxDBSink) Write(service string, report *mm.Report) error {
+	log.Debug("InfluxDBSink.Write:call")
+	defer log.Debug("InfluxDBSink.Write:return")
+
+	// Write every batch even if one fails so as little as possible is lost.
+	lines := Lines(report)
+	var firstErr error
+	for len(lines) > 0 {
+		n := s.config.BatchSize
+		if n > len(lines) {
+			n = len(lines)
+		}
+		if err := s.post(lines[:n]); err != nil {
+			mm.Self.Inc("self/sink/errors", s.selfLabels)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		lines = lines[n:]
+	}
+	return firstErr
+}
+
+func (s *InfluxDBSink) Close() {
+}
+
+// post sends the lines.  Only network errors and 5xx and 429 responses are
+// worth retrying; other errors are mm.ErrNoRetry.
+func (s *InfluxDBSink) post(lines []string) error {
+	body, err := s.encode(lines)
+	if err != nil {
+		return mm.ErrNoRetry{Err: err}
+	}
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+	retry, err := s.send(body)
+	if err != nil && !retry {
+		return mm.ErrNoRetry{Err: err}
+	}
+	return err
+}
+
+func (s *InfluxDBSink) encode(lines []string) ([]byte, error) {
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	if !s.config.Gzip {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// send posts the body once.  It returns true if the request can be retried.
+func (s *InfluxDBSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.config.Version == 2 {
+		if s.config.Token != "" {
+			req.Header.Set("Authorization", "Token "+s.config.Token)
+		}
+	} else if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("InfluxDB returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// --------------------------------------------------------------------------
+// Line protocol
+// --------------------------------------------------------------------------
+
+// Lines returns the report as line protocol, one line per metric and event.
+func Lines(report *mm.Report) []string {
+	lines := []string{}
+	ts := strconv.FormatInt(report.Ts.Unix(), 10)
+	for _, is := range report.Stats {
+		keys := make([]string, 0, len(is.Stats))
+		for key := range is.Stats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			stats := is.Stats[key]
+			fields := []string{"cnt=" + strconv.Itoa(stats.Cnt) + "i"}
+			for _, f := range []struct {
+				name  string
+				value float64
+			}{
+				{"min", stats.Min},
+				{"pct5", stats.Pct5},
+				{"avg", stats.Avg},
+				{"med", stats.Med},
+				{"pct95", stats.Pct95},
+				{"max", stats.Max},
+			} {
+				// Line protocol has no NaN or Inf.
+				if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+					continue
+				}
+				fields = append(fields, f.name+"="+strconv.FormatFloat(f.value, 'f', -1, 64))
+			}
+			tags := map[string]string{}
+			for k, v := range stats.Labels {
+				tags[k] = v
+			}
+			tags["instance"] = is.Instance
+			lines = append(lines, escapeMeasurement(stats.Name)+tagSet(tags)+" "+strings.Join(fields, ",")+" "+ts)
+		}
+	}
+	for _, e := range report.Events {
+		tags := map[string]string{"instance": e.Instance, "type": e.Type}
+		text := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(e.Text)
+		lines = append(lines, eventMeasurement+tagSet(tags)+` text="`+text+`" `+strconv.FormatInt(e.Ts, 10))
+	}
+	return lines
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func escapeMeasurement(s string) string {
+	return measurementEscaper.Replace(s)
+}
+
+// tagSet returns ",k1=v1,k2=v2" sorted by key, as InfluxDB recommends.
+// Tags with empty values are skipped because line protocol doesn't allow them.
+func tagSet(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	set := ""
+	for _, k := range keys {
+		set += "," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(tags[k])
+	}
+	return set
+}
@@ -0,0 +1,230 @@
+package influxdbSink
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+func report(name string, labels map[string]string, events ...mm.Event) *mm.Report {
+	return &mm.Report{
+		Ts:       time.Unix(1600000000, 0),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				Instance: "db1",
+				Stats: map[string]*mm.Stats{
+					mm.MetricKey(name, labels): {Name: name, Labels: labels, Cnt: 2, Min: 1, Pct5: 1, Avg: 1.5, Med: 2, Pct95: 2, Max: 2},
+				},
+			},
+		},
+		Events: events,
+	}
+}
+
+func TestLines(t *testing.T) {
+	stats := "cnt=2i,min=1,pct5=1,avg=1.5,med=2,pct95=2,max=2 1600000000"
+	tests := []struct {
+		report *mm.Report
+		lines  []string
+	}{
+		{
+			report("mysql/threads_running", nil),
+			[]string{"mysql/threads_running,instance=db1 " + stats},
+		},
+		{
+			// Measurement commas and spaces are escaped, = isn't.
+			report("exec/a b,c=d", nil),
+			[]string{`exec/a\ b\,c=d,instance=db1 ` + stats},
+		},
+		{
+			// Tag keys and values escape commas, = and spaces; empty
+			// values are skipped; tags are sorted.
+			report("os/disk/reads", map[string]string{"device": "sd a", "k=1": "v,1", "empty": ""}),
+			[]string{`os/disk/reads,device=sd\ a,instance=db1,k\=1=v\,1 ` + stats},
+		},
+		{
+			// Event text escapes " and \.
+			report("mysql/uptime", nil, mm.Event{Ts: 1599999000, Instance: "db 1", Type: mm.EventVariable, Text: `sql_mode changed from "" to "C:\x"`}),
+			[]string{
+				"mysql/uptime,instance=db1 " + stats,
+				`events,instance=db\ 1,type=variable text="sql_mode changed from \"\" to \"C:\\x\"" 1599999000`,
+			},
+		},
+	}
+	for _, test := range tests {
+		lines := Lines(test.report)
+		if strings.Join(lines, "\n") != strings.Join(test.lines, "\n") {
+			t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(lines, "\n"), strings.Join(test.lines, "\n"))
+		}
+	}
+
+	// NaN and Inf stats are skipped; carried stats are written with cnt=0.
+	r := report("mysql/threads_running", nil)
+	s := r.Stats[0].Stats["mysql/threads_running"]
+	s.Pct5 = math.NaN()
+	s.Max = math.Inf(1)
+	r.Stats[0].Stats["mysql/carried"] = &mm.Stats{Name: "mysql/carried", Carried: true,
+		Min: 1, Pct5: 1, Avg: 1, Med: 1, Pct95: 1, Max: 1}
+	lines := Lines(r)
+	expect := []string{
+		"mysql/carried,instance=db1 cnt=0i,min=1,pct5=1,avg=1,med=1,pct95=1,max=1 1600000000",
+		"mysql/threads_running,instance=db1 cnt=2i,min=1,avg=1.5,med=2,pct95=2 1600000000",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("got %q, expected %q", lines, expect)
+	}
+}
+
+type request struct {
+	url           string
+	authorization string
+	body          string
+}
+
+// server returns an InfluxDB stand-in that answers the statuses in order,
+// then 204, and sends every request to requests.
+func server(t *testing.T, requests chan request, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = gz
+		}
+		data, _ := ioutil.ReadAll(body)
+		requests <- request{r.URL.String(), r.Header.Get("Authorization"), string(data)}
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		config        func(c *Config)
+		url           string
+		authorization string
+	}{
+		{
+			func(c *Config) {},
+			"/write?db=metrics&precision=s",
+			"",
+		},
+		{
+			func(c *Config) {
+				c.RetentionPolicy = "week"
+				c.Username = "u"
+				c.Password = "p"
+				c.Gzip = false
+			},
+			"/write?db=metrics&precision=s&rp=week",
+			"Basic dTpw",
+		},
+		{
+			func(c *Config) {
+				c.Version = 2
+				c.Org = "my org"
+				c.Bucket = "b1"
+				c.Token = "t1"
+			},
+			"/api/v2/write?bucket=b1&org=my+org&precision=s",
+			"Token t1",
+		},
+	}
+	for _, test := range tests {
+		requests := make(chan request, 1)
+		ts := server(t, requests)
+		config := DefaultConfig()
+		config.URL = ts.URL + "/"
+		test.config(config)
+		if err := config.validate(); err != nil {
+			t.Fatal(err)
+		}
+		s := NewInfluxDBSink("influxdb", config)
+		if err := s.Write("mm", report("mysql/threads_running", nil)); err != nil {
+			t.Error(err)
+		}
+		ts.Close()
+		req := <-requests
+		if req.url != test.url {
+			t.Errorf("got URL %s, expected %s", req.url, test.url)
+		}
+		if req.authorization != test.authorization {
+			t.Errorf("got Authorization %q, expected %q", req.authorization, test.authorization)
+		}
+		if !strings.HasPrefix(req.body, "mysql/threads_running,instance=db1 cnt=2i,") {
+			t.Errorf("got body %q", req.body)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		noRetry bool
+	}{
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+	}
+	for _, test := range tests {
+		requests := make(chan request, 1)
+		ts := server(t, requests, test.status)
+		config := DefaultConfig()
+		config.URL = ts.URL
+		err := NewInfluxDBSink("influxdb", config).Write("mm", report("mysql/threads_running", nil))
+		ts.Close()
+		if err == nil {
+			t.Errorf("%d: no error", test.status)
+			continue
+		}
+		if _, noRetry := err.(mm.ErrNoRetry); noRetry != test.noRetry {
+			t.Errorf("%d: got %T %s, expected ErrNoRetry %t", test.status, err, err, test.noRetry)
+		}
+	}
+
+	// Network errors are worth retrying.
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	config := DefaultConfig()
+	config.URL = ts.URL
+	err := NewInfluxDBSink("influxdb", config).Write("mm", report("mysql/threads_running", nil))
+	if _, noRetry := err.(mm.ErrNoRetry); err == nil || noRetry {
+		t.Errorf("got %T %v, expected a network error", err, err)
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	requests := make(chan request, 3)
+	ts := server(t, requests, http.StatusServiceUnavailable)
+	defer ts.Close()
+	config := DefaultConfig()
+	config.URL = ts.URL
+	config.BatchSize = 1
+	r := report("a", nil, mm.Event{Ts: 1, Instance: "db1", Type: mm.EventRestart, Text: "restart"})
+	r.Stats[0].Stats["b"] = &mm.Stats{Name: "b", Cnt: 1}
+
+	// Every batch is sent even if one fails.
+	if err := NewInfluxDBSink("influxdb", config).Write("mm", r); err == nil {
+		t.Error("no error")
+	}
+	if len(requests) != 3 {
+		t.Errorf("%d requests, expected 3", len(requests))
+	}
+}
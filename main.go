@@ -1,36 +1,99 @@
 package main
 
+import "flag"
 import "fmt"
 import "os"
 import "os/signal"
-import "time"
 
 import (
+	"./api"
+	_ "./boltSink"
+	_ "./execCollector"
+	_ "./fileSink"
+	_ "./graphiteSink"
+	_ "./influxdbSink"
 	"./mm"
-	"./mysqlCollector"
+	_ "./mysqlCollector"
+	_ "./osCollector"
+	_ "./otlpSink"
+	_ "./prometheusSink"
+	_ "./statsdSink"
 	log "github.com/Sirupsen/logrus"
 )
 
+var flagConfig string
+
+func init() {
+	flag.StringVar(&flagConfig, "config", "", "JSON config file; collects MySQL on localhost and OS metrics if not set")
+}
+
 func main() {
+	flag.Parse()
 	log.SetOutput(os.Stderr)
 	log.SetLevel(log.DebugLevel)
 
+	config := DefaultConfig()
+	if flagConfig != "" {
+		var err error
+		if config, err = LoadConfig(flagConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot load config %s: %s\n", flagConfig, err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Println("Collector starts")
-	mc := mysqlCollector.NewMysqlCollector("root@tcp(localhost:3306)/test")
-	clock := time.NewTicker(time.Second * 1)
-	collectionChan := make(chan *mm.Collection)
-	mc.Start(clock.C, collectionChan)
+	sinks, err := mm.NewDispatcher(config.SinkConfigs())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	sinks.Start()
 
-	spool := &mm.DataStorage{}
-	ag := mm.NewAggregator(collectionChan, *spool)
+	queue, err := mm.NewQueue(config.QueueSize, config.QueuePolicy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	queue.Start()
+
+	collectors := mm.NewRegistry(queue.In())
+	collectors.SetReader(sinks.Reader())
+	for _, c := range config.Collectors {
+		if err := collectors.Add(c); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			collectors.Stop()
+			os.Exit(1)
+		}
+	}
+	collectors.AddCollector(mm.NewSelfCollector("self", queue), 1)
+
+	ag := mm.NewAggregator(config.Interval, config.Grace, queue.Out(), sinks)
 	ag.Start()
 
+	var httpAPI *api.API
+	if config.API != "" {
+		httpAPI = api.NewAPI(config.API, config.GrafanaOrigin, collectors, queue, ag, sinks, config.Masked())
+		if err := httpAPI.Start(); err != nil {
+			log.Error("Cannot start API: ", err)
+		}
+	}
+
 	signalChan := make(chan os.Signal, 1)
 	cleanupDone := make(chan bool)
 	signal.Notify(signalChan, os.Interrupt)
 	go func() {
 		for _ = range signalChan {
 			fmt.Println("\nReceived an interrupt, stopping services...\n")
+			if httpAPI != nil {
+				httpAPI.Stop()
+			}
+			// Stop in pipeline order so what's in flight is reported:
+			// the queue sends what it buffered to the aggregator, which
+			// reports the open intervals to the sinks, which write them.
+			collectors.Stop()
+			queue.Stop()
+			ag.Stop()
+			sinks.Close()
 			cleanupDone <- true
 		}
 	}()
@@ -20,26 +20,51 @@ package mm
 import (
 	"fmt"
 	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 import (
+	"../pct"
 	log "github.com/Sirupsen/logrus"
 )
 
 type Aggregator struct {
 	interval       int64
+	grace          int64
 	collectionChan chan *Collection
-	spool          DataStorage
+	spool          Sink
+	samples        SampleSink // spool if it takes collections, else nil
+	dropped        uint64
+	status         *pct.Status
+	started        time.Time
+	lastReport     time.Time // when the last report was written
+	storageOk      bool      // last report was written without error
+	mux            *sync.Mutex
+	stopChan       chan bool
+	doneChan       chan bool
 }
 
-func NewAggregator(collectionChan chan *Collection, spool DataStorage) *Aggregator {
+// Collections with a Ts more than this many intervals before now are
+// rejected, like those in the future: the collector's clock is wrong.
+const maxCollectionAge = 10
+
+// NewAggregator returns an Aggregator that reports stats every interval
+// seconds.  An interval is kept open for grace seconds after it ends so
+// collections that arrive late still count in their own interval.
+func NewAggregator(interval, grace int64, collectionChan chan *Collection, spool Sink) *Aggregator {
 	a := &Aggregator{
-		interval:       60,
+		interval:       interval,
+		grace:          grace,
 		collectionChan: collectionChan,
 		spool:          spool,
 		// --
+		status: pct.NewStatus([]string{"aggregator", "aggregator-last-report", "storage"}),
+		mux:    &sync.Mutex{},
 	}
+	a.samples, _ = spool.(SampleSink)
 	return a
 }
 
@@ -49,11 +74,45 @@ func NewAggregator(collectionChan chan *Collection, spool DataStorage) *Aggregat
 
 // @goroutine[0]
 func (a *Aggregator) Start() {
+	a.mux.Lock()
+	a.started = time.Now()
+	a.storageOk = true
+	a.mux.Unlock()
+	a.stopChan = make(chan bool)
+	a.doneChan = make(chan bool)
+	a.status.Update("aggregator", "Running")
 	go a.run()
 }
 
+func (a *Aggregator) Status() map[string]string {
+	return a.status.All()
+}
+
+// Healthy returns an error if no report has been written for more than two
+// intervals, or if the last report could not be written.
+func (a *Aggregator) Healthy() error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if !a.storageOk {
+		return fmt.Errorf("Storage failed: %s", a.status.Get("storage"))
+	}
+	last := a.lastReport
+	if last.IsZero() {
+		last = a.started
+	}
+	max := time.Duration(2*a.interval+a.grace) * time.Second
+	if d := time.Since(last); d > max {
+		return fmt.Errorf("No report written for %s", d)
+	}
+	return nil
+}
+
+// Stop reports the intervals still open, even if they're incomplete, then
+// stops aggregating.  Stop the collectors first.
 // @goroutine[0]
 func (a *Aggregator) Stop() {
+	close(a.stopChan)
+	<-a.doneChan
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -65,80 +124,192 @@ func (a *Aggregator) run() {
 	defer func() {
 		if err := recover(); err != nil {
 			log.Error("Aggregator crashed: ", err)
+			a.status.Update("aggregator", fmt.Sprintf("Crashed: %s", err))
 		}
+		close(a.doneChan)
 	}()
 
-	var curInterval int64
-	var startTs time.Time
+	// Collections are buffered by interval until the interval closes, i.e.
+	// until a collection at least grace seconds past its end arrives.  Then
+	// they're added to the stats in time order, so late and out-of-order
+	// collections count in the right interval and counter rates are right.
+	pending := make(map[int64][]*Collection) // keyed on interval start ts
+	var oldest int64                         // oldest open interval
+	var latest int64                         // most recent collection ts
+	var npending int
 	cur := []*InstanceStats{}
-	var is *InstanceStats
-
-	is = &InstanceStats{
-		Stats: make(map[string]*Stats),
-	}
-	cur = append(cur, is)
 
 	for {
 		select {
 		case collection := <-a.collectionChan:
+			// Samples are written as collected, even if they're too late to
+			// be aggregated.
+			if a.samples != nil {
+				a.writeSamples(collection)
+			}
+
+			now := time.Now().Unix()
+			if collection.Ts > now+a.grace+1 || collection.Ts < now-maxCollectionAge*a.interval {
+				// One collection far in the future would close every open
+				// interval, and every later collection would be late.
+				n := atomic.AddUint64(&a.dropped, 1)
+				Self.Inc("self/aggregator/dropped", nil)
+				log.Warn(fmt.Sprintf("Dropped %s collection with ts %s, %ds from now; check the clock; %d dropped",
+					collection.Instance, time.Unix(collection.Ts, 0).UTC(), collection.Ts-now, n))
+				continue
+			}
+
 			interval := (collection.Ts / a.interval) * a.interval
-			if curInterval == 0 {
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				log.Debug("Start first interval", startTs)
+			if oldest == 0 {
+				oldest = interval
+				log.Debug("Start first interval", GoTime(a.interval, interval))
 			}
-			if interval > curInterval {
-				// Metrics for next interval have arrived.  Process and spool
-				// the current interval, then advance to this interval.
-				a.report(startTs, cur)
-
-				// Init next stats based on current ones to avoid re-creating them.
-				// todo: what if metrics from an instance aren't collected?
-				for n := range cur {
-					for key, _ := range cur[n].Stats {
-						cur[n].Stats[key].Reset()
-					}
-				}
-				curInterval = interval
-				startTs = GoTime(a.interval, interval)
-				log.Debug("Start interval", startTs)
-			} else if interval < curInterval {
-				t := GoTime(a.interval, interval)
-				log.Info("Lost collection for interval", t, "; current interval is", startTs)
+			if interval < oldest {
+				// The interval was already reported.
+				n := atomic.AddUint64(&a.dropped, 1)
+				Self.Inc("self/aggregator/dropped", nil)
+				log.Info("Dropped late collection for interval ", GoTime(a.interval, interval),
+					"; oldest open interval is ", GoTime(a.interval, oldest), "; ", n, " dropped")
+				continue
+			}
+			pending[interval] = append(pending[interval], collection)
+			npending++
+			if collection.Ts > latest {
+				latest = collection.Ts
+			}
+
+			// Report every interval whose grace window has passed.  The
+			// first open one is the one latest-grace is in.
+			open := ((latest - a.grace) / a.interval) * a.interval
+			for _, ts := range pendingBefore(pending, open) {
+				npending -= len(pending[ts])
+				cur = a.reportInterval(ts, pending[ts], cur)
+				delete(pending, ts)
+			}
+			if open > oldest {
+				oldest = open
+				log.Debug("Start interval", GoTime(a.interval, oldest))
+			}
+			Self.Set("self/aggregator/pending", nil, float64(npending))
+		case <-a.stopChan:
+			for _, ts := range pendingBefore(pending, math.MaxInt64) {
+				cur = a.reportInterval(ts, pending[ts], cur)
+			}
+			a.status.Update("aggregator", "Stopped")
+			return
+		}
+	}
+}
+
+// pendingBefore returns the pending intervals that start before ts, sorted.
+func pendingBefore(pending map[int64][]*Collection, ts int64) []int64 {
+	intervals := []int64{}
+	for interval := range pending {
+		if interval < ts {
+			intervals = append(intervals, interval)
+		}
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals
+}
+
+// reportInterval adds the collections of the interval starting at ts to the
+// stats in time order, then reports them.
+// @goroutine[1]
+func (a *Aggregator) reportInterval(ts int64, collections []*Collection, cur []*InstanceStats) []*InstanceStats {
+	sort.Sort(byTs(collections))
+	events := []Event{}
+	for _, c := range collections {
+		cur = a.add(cur, c)
+		for _, e := range c.Events {
+			if e.Instance == "" {
+				e.Instance = c.Instance
 			}
+			events = append(events, e)
+		}
+	}
+	a.report(GoTime(a.interval, ts), cur, events)
+
+	// Init next stats based on current ones to avoid re-creating them.
+	// todo: what if metrics from an instance aren't collected?
+	for n := range cur {
+		for key, _ := range cur[n].Stats {
+			cur[n].Stats[key].Reset()
+		}
+	}
+	return cur
+}
+
+// @goroutine[1]
+func (a *Aggregator) writeSamples(c *Collection) {
+	if err := a.samples.WriteCollection(c); err != nil {
+		Self.Inc("self/storage/sample_errors", nil)
+		log.Debug("Lost samples: ", err)
+	}
+}
+
+// Dropped returns how many collections arrived too late to be reported.
+func (a *Aggregator) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
 
-			// Each collection is from a specific service instance.
-			// Find the stats for this instance, create if they don't exist.
-
-			// Add each metric in the collection to its Stats.
-			for _, metric := range collection.Metrics {
-				stats, haveStats := is.Stats[metric.Name]
-				if !haveStats {
-					// New metric, create stats for it.
-					var err error
-					stats, err = NewStats(metric.Type)
-					if err != nil {
-						log.Error(metric.Name, "invalid:", err.Error())
-						continue
-					}
-					is.Stats[metric.Name] = stats
-				}
-				if err := stats.Add(&metric, collection.Ts); err != nil {
-					f := log.Error
-					switch err.(type) {
-					case ErrValueLap:
-						// Treat this error as info
-						f = log.Info
-					}
-					f(fmt.Sprintf("stats.Add(%+v, %d): %s", metric, collection.Ts, err))
-				}
+// @goroutine[1]
+func (a *Aggregator) add(cur []*InstanceStats, collection *Collection) []*InstanceStats {
+	// Each collection is from a specific service instance.
+	// Find the stats for this instance, create if they don't exist.
+	var is *InstanceStats
+	for _, i := range cur {
+		if i.Instance == collection.Instance {
+			is = i
+			break
+		}
+	}
+	if is == nil {
+		is = &InstanceStats{
+			Instance: collection.Instance,
+			Stats:    make(map[string]*Stats),
+		}
+		cur = append(cur, is)
+	}
+
+	// Add each metric in the collection to its Stats.
+	for _, metric := range collection.Metrics {
+		key := metric.Key()
+		stats, haveStats := is.Stats[key]
+		if !haveStats {
+			// New metric, create stats for it.
+			var err error
+			stats, err = NewStats(metric.Type)
+			if err != nil {
+				log.Error(key, "invalid:", err.Error())
+				continue
+			}
+			stats.Name = metric.Name
+			stats.Labels = metric.Labels
+			is.Stats[key] = stats
+		}
+		stats.SetInterval(collection.Interval)
+		if err := stats.Add(&metric, collection.Ts); err != nil {
+			f := log.Error
+			switch err.(type) {
+			case ErrValueLap:
+				// Treat this error as info
+				f = log.Info
 			}
+			f(fmt.Sprintf("stats.Add(%+v, %d): %s", metric, collection.Ts, err))
 		}
 	}
+	return cur
 }
 
+type byTs []*Collection
+
+func (c byTs) Len() int           { return len(c) }
+func (c byTs) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byTs) Less(i, j int) bool { return c[i].Ts < c[j].Ts }
+
 // @goroutine[1]
-func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
+func (a *Aggregator) report(startTs time.Time, is []*InstanceStats, events []Event) {
 	log.Debug("Summarize metrics for", startTs)
 
 	// The instance stats given (is) are a persistent buffer, so we need
@@ -151,10 +322,14 @@ func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
 	for _, i := range is {
 
 		// Finalize the stats for every metric.  If the final stats are nil,
-		// then no values were reported (Cnt=0), so we ignore the metric.
+		// then no values were reported (Cnt=0), so we ignore the metric
+		// unless it's collected less often than every interval.
 		finalMetrics := make(map[string]*Stats)
 		for metric, stats := range i.Stats {
 			finalStats := stats.Finalize()
+			if finalStats == nil {
+				finalStats = stats.Carry(startTs.Unix(), a.interval)
+			}
 			if finalStats == nil {
 				// No values, so no stats; ignore the metric.
 				continue
@@ -172,7 +347,8 @@ func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
 
 		// Create a copy of this instance with the copy of its stats.
 		finalInstance := &InstanceStats{
-			Stats: finalMetrics,
+			Instance: i.Instance,
+			Stats:    finalMetrics,
 		}
 		finalInstanceStats = append(finalInstanceStats, finalInstance)
 	}
@@ -180,16 +356,41 @@ func (a *Aggregator) report(startTs time.Time, is []*InstanceStats) {
 	if len(finalInstanceStats) == 0 {
 		// This shouldn't happen: no instances with valid metrics/stats.
 		log.Warn("No metrics collected for", startTs)
-		return
+		if len(events) == 0 {
+			return
+		}
 	}
 
 	report := &Report{
 		Ts:       startTs,
 		Duration: uint(a.interval),
 		Stats:    finalInstanceStats,
+		Events:   events,
 	}
-	if err := a.spool.Write("mm", report); err != nil {
+	t0 := time.Now()
+	err := a.spool.Write("mm", report)
+	if err != nil {
 		log.Warn("Lost report:", err)
+		Self.Inc("self/storage/write_errors", nil)
+		a.status.Update("storage", "Error: "+err.Error())
+	} else {
+		a.status.Update("storage", "Ok")
+		a.status.Update("aggregator-last-report", startTs.Format(time.RFC3339))
+	}
+	a.mux.Lock()
+	a.lastReport = time.Now()
+	a.storageOk = err == nil
+	a.mux.Unlock()
+	Self.Set("self/storage/write_seconds", nil, time.Since(t0).Seconds())
+
+	// Time from the end of the interval to the report being written.
+	end := startTs.Add(time.Duration(a.interval) * time.Second)
+	Self.Set("self/aggregator/report_latency_seconds", nil, time.Since(end).Seconds())
+
+	// Collections queue while the report is written, so if writing takes
+	// a good part of the interval, storage is the bottleneck.
+	if d := time.Since(t0); d > time.Duration(a.interval)*time.Second/2 {
+		log.Warn(fmt.Sprintf("Writing report for %s took %s; storage is too slow for a %ds interval", startTs, d, a.interval))
 	}
 }
 
@@ -0,0 +1,130 @@
+package mm
+
+import (
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	reports chan *Report
+}
+
+func (s *testSink) Write(service string, report *Report) error {
+	s.reports <- report
+	return nil
+}
+
+func (s *testSink) Close() {
+}
+
+func collection(ts int64, value float64) *Collection {
+	return &Collection{
+		Instance: "db1",
+		Ts:       ts,
+		Metrics:  []Metric{{Name: "threads_running", Type: "gauge", Number: value}},
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	sink := &testSink{reports: make(chan *Report, 10)}
+	collectionChan := make(chan *Collection)
+	a := NewAggregator(60, 5, collectionChan, sink)
+	a.Start()
+
+	// Three intervals ending before now.
+	start := (time.Now().Unix()/60)*60 - 180
+	collectionChan <- collection(start, 1)
+	collectionChan <- collection(start+30, 3)
+	collectionChan <- collection(start+62, 5) // closes nothing: within grace
+
+	// Late but within grace: still counts in the first interval.
+	collectionChan <- collection(start+59, 2)
+
+	// Far in the future: dropped, doesn't close any interval.
+	collectionChan <- collection(start+86400, 100)
+
+	// Closes the first interval, and the second but it has nothing new.
+	collectionChan <- collection(start+125, 6)
+
+	select {
+	case r := <-sink.reports:
+		if r.Ts.Unix() != start {
+			t.Errorf("first report ts %d, expected %d", r.Ts.Unix(), start)
+		}
+		if s := r.Stats[0].Stats["threads_running"]; s.Cnt != 3 || s.Max != 3 {
+			t.Errorf("first report Cnt=%d Max=%f, expected Cnt=3 Max=3", s.Cnt, s.Max)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no first report")
+	}
+	select {
+	case r := <-sink.reports:
+		if r.Ts.Unix() != start+60 {
+			t.Errorf("second report ts %d, expected %d", r.Ts.Unix(), start+60)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no second report")
+	}
+
+	// Too late: the first interval was reported.
+	collectionChan <- collection(start+10, 50)
+
+	// Stop reports the open interval.
+	a.Stop()
+	if n := a.Dropped(); n != 2 {
+		t.Errorf("%d dropped, expected 2", n)
+	}
+	select {
+	case r := <-sink.reports:
+		if r.Ts.Unix() != start+120 {
+			t.Errorf("last report ts %d, expected %d", r.Ts.Unix(), start+120)
+		}
+		if s := r.Stats[0].Stats["threads_running"]; s.Cnt != 1 || s.Max != 6 {
+			t.Errorf("last report Cnt=%d Max=%f, expected Cnt=1 Max=6", s.Cnt, s.Max)
+		}
+	default:
+		t.Fatal("Stop did not report the open interval")
+	}
+}
+
+func TestAggregatorCarriesSlowMetrics(t *testing.T) {
+	sink := &testSink{reports: make(chan *Report, 10)}
+	collectionChan := make(chan *Collection)
+	a := NewAggregator(60, 5, collectionChan, sink)
+	a.Start()
+
+	// table/rows is collected every 300s, threads_running every interval.
+	start := (time.Now().Unix()/300)*300 - 300
+	collectionChan <- &Collection{
+		Instance: "db1",
+		Ts:       start,
+		Interval: 300,
+		Metrics:  []Metric{{Name: "table/rows", Type: "gauge", Number: 100}},
+	}
+	for ts := start; ts < start+300; ts += 60 {
+		collectionChan <- collection(ts, 1)
+	}
+	a.Stop()
+
+	// It's in every 60s report until its next value is due: first with its
+	// value, then carried forward.
+	for i := int64(0); i < 5; i++ {
+		select {
+		case r := <-sink.reports:
+			if r.Ts.Unix() != start+i*60 {
+				t.Errorf("report %d ts %d, expected %d", i, r.Ts.Unix(), start+i*60)
+			}
+			s := r.Stats[0].Stats["table/rows"]
+			switch {
+			case s == nil:
+				t.Errorf("report %d: no table/rows", i)
+			case i == 0 && (s.Carried || s.Cnt != 1 || s.Avg != 100):
+				t.Errorf("report 0: got %+v, expected Cnt=1 Avg=100", s)
+			case i > 0 && (!s.Carried || s.Cnt != 0 || s.Avg != 100 || s.Last != 100):
+				t.Errorf("report %d: got %+v, expected Carried Cnt=0 Avg=100 Last=100", i, s)
+			}
+		default:
+			t.Fatalf("no report %d", i)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package mm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// A Collector is a source of metrics, e.g. MySQL or the OS.  On every tick
+// it collects metrics and sends them as a Collection to an Aggregator.
+type Collector interface {
+	Name() string
+	Start(tickChan <-chan time.Time, collectionChan chan *Collection) error
+	Stop() error
+	Status() map[string]string
+}
+
+// A HistoryCollector is a Collector that queries what's been stored, e.g. to
+// project a trend that outlasts restarts.  The Registry gives it the Reader
+// before starting it, if storage can be queried.
+type HistoryCollector interface {
+	Collector
+	SetReader(reader Reader)
+}
+
+// A CollectorFactory makes a Collector from its JSON config, which is empty
+// if the collector has no config.
+type CollectorFactory func(name string, config []byte) (Collector, error)
+
+type CollectorConfig struct {
+	Type     string          // mysql, os, etc.
+	Name     string          // unique, defaults to Type
+	Interval int64           // seconds between ticks, default 1
+	Config   json.RawMessage // collector-specific
+}
+
+var (
+	factoriesMux = &sync.Mutex{}
+	factories    = make(map[string]CollectorFactory)
+)
+
+// RegisterCollector makes a collector type available to Registry.Add.  It's
+// usually called from the init() of the package implementing the collector.
+func RegisterCollector(collectorType string, factory CollectorFactory) {
+	factoriesMux.Lock()
+	defer factoriesMux.Unlock()
+	if _, ok := factories[collectorType]; ok {
+		panic("mm: RegisterCollector called twice for " + collectorType)
+	}
+	factories[collectorType] = factory
+}
+
+// CollectorTypes returns the registered collector types, sorted.
+func CollectorTypes() []string {
+	factoriesMux.Lock()
+	defer factoriesMux.Unlock()
+	types := []string{}
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Registry
+/////////////////////////////////////////////////////////////////////////////
+
+type runningCollector struct {
+	collector Collector
+	ticker    *time.Ticker
+	started   time.Time
+}
+
+// A Registry starts, stops and reports the status of the collectors it has.
+type Registry struct {
+	collectionChan chan *Collection
+	collectors     map[string]*runningCollector
+	reader         Reader // for HistoryCollectors, nil if storage can't be queried
+	mux            *sync.Mutex
+}
+
+func NewRegistry(collectionChan chan *Collection) *Registry {
+	r := &Registry{
+		collectionChan: collectionChan,
+		collectors:     make(map[string]*runningCollector),
+		mux:            &sync.Mutex{},
+	}
+	return r
+}
+
+// SetReader sets the Reader given to HistoryCollectors started after it.
+func (r *Registry) SetReader(reader Reader) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.reader = reader
+}
+
+// Add makes a collector from the config and starts it.
+func (r *Registry) Add(config CollectorConfig) error {
+	if config.Name == "" {
+		config.Name = config.Type
+	}
+	if config.Interval <= 0 {
+		config.Interval = 1
+	}
+
+	factoriesMux.Lock()
+	factory, ok := factories[config.Type]
+	factoriesMux.Unlock()
+	if !ok {
+		return fmt.Errorf("Unknown collector type: %s", config.Type)
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.collectors[config.Name]; ok {
+		return fmt.Errorf("Duplicate collector name: %s", config.Name)
+	}
+
+	c, err := factory(config.Name, config.Config)
+	if err != nil {
+		return fmt.Errorf("Invalid %s collector config: %s", config.Name, err)
+	}
+	return r.start(c, config.Interval)
+}
+
+// AddCollector starts a collector made by the caller, e.g. one that needs
+// more than its config, ticking every interval seconds.
+func (r *Registry) AddCollector(c Collector, interval int64) error {
+	if interval <= 0 {
+		interval = 1
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("Duplicate collector name: %s", c.Name())
+	}
+	return r.start(c, interval)
+}
+
+// Remove stops the collector and removes it from the registry.
+func (r *Registry) Remove(name string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	rc, ok := r.collectors[name]
+	if !ok {
+		return fmt.Errorf("Unknown collector: %s", name)
+	}
+	delete(r.collectors, name)
+	return r.stop(rc)
+}
+
+// Stop stops all collectors.
+func (r *Registry) Stop() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for name, rc := range r.collectors {
+		if err := r.stop(rc); err != nil {
+			log.Warn(err)
+		}
+		delete(r.collectors, name)
+	}
+}
+
+// Collectors returns the running collectors keyed on name.
+func (r *Registry) Collectors() map[string]Collector {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	collectors := make(map[string]Collector)
+	for name, rc := range r.collectors {
+		collectors[name] = rc.collector
+	}
+	return collectors
+}
+
+// Status returns the status of every collector keyed on name.
+func (r *Registry) Status() map[string]map[string]string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	status := make(map[string]map[string]string)
+	for name, rc := range r.collectors {
+		s := make(map[string]string)
+		for k, v := range rc.collector.Status() {
+			s[k] = v
+		}
+		s["started"] = rc.started.UTC().Format(time.RFC3339)
+		status[name] = s
+	}
+	return status
+}
+
+func (r *Registry) start(c Collector, interval int64) error {
+	if hc, ok := c.(HistoryCollector); ok && r.reader != nil {
+		hc.SetReader(r.reader)
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	if err := c.Start(ticker.C, r.collectionChan); err != nil {
+		ticker.Stop()
+		return fmt.Errorf("Cannot start %s collector: %s", c.Name(), err)
+	}
+	log.Info("Started ", c.Name(), " collector")
+
+	r.collectors[c.Name()] = &runningCollector{
+		collector: c,
+		ticker:    ticker,
+		started:   time.Now(),
+	}
+	return nil
+}
+
+func (r *Registry) stop(rc *runningCollector) error {
+	rc.ticker.Stop()
+	if err := rc.collector.Stop(); err != nil {
+		return fmt.Errorf("Cannot stop %s collector: %s", rc.collector.Name(), err)
+	}
+	log.Info("Stopped ", rc.collector.Name(), " collector")
+	return nil
+}
@@ -0,0 +1,378 @@
+package mm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Dispatcher defaults, used when the SinkConfig value is zero.
+const (
+	DefaultSinkQueueSize       = 100
+	DefaultSinkSampleQueueSize = 1000
+	DefaultSinkRetries         = 2
+	DefaultSinkRetryWait       = 5
+)
+
+// How long Close waits for sinks to finish the write in progress.
+const dispatcherCloseTimeout = 10 * time.Second
+
+// A Dispatcher is the Sink the Aggregator writes to; it writes to one or
+// several sinks, e.g. Mongo plus Prometheus plus file.  Every sink has its own queue
+// and goroutine, so Write never blocks: a slow or failing sink only fills
+// its own queue, dropping its oldest reports, and retries per its own config
+// while the others keep writing.  Collections are queued for SampleSinks,
+// and their samples for sinks with Raw, in a second queue so that they,
+// arriving every tick, don't push the reports out of a sink that's behind.
+// They aren't retried, and reports are written first.
+type Dispatcher struct {
+	outputs []*sinkOutput
+	rater   *Rater // if a sink has Raw
+	status  *pct.Status
+	stop    chan bool
+	mux     *sync.Mutex // guards sinkOutput.err
+}
+
+type sinkOutput struct {
+	name       string
+	sink       Sink
+	samples    SampleSink // sink if it takes collections, else nil
+	raw        RawSink    // sink if Raw, else nil
+	config     SinkConfig
+	queue      chan sinkItem // reports
+	sampleQ    chan sinkItem // collections and raw samples, nil if neither
+	err        error         // of the last report write
+	done       chan bool     // closed when run returns, nil if not started
+	selfLabels map[string]string
+}
+
+// A sinkItem is a report, a collection for a SampleSink, or raw samples.
+type sinkItem struct {
+	report     *Report
+	collection *Collection
+	samples    []Sample
+}
+
+// NewDispatcher makes every sink.  If one can't be made, the ones already
+// made are closed and the error is returned.
+func NewDispatcher(configs []SinkConfig) (*Dispatcher, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("No sinks")
+	}
+	d := &Dispatcher{
+		stop: make(chan bool),
+		mux:  &sync.Mutex{},
+	}
+	names := []string{}
+	for _, config := range configs {
+		if config.Name == "" {
+			config.Name = config.Type
+		}
+		for _, name := range names {
+			if name == "sink-"+config.Name {
+				d.closeSinks()
+				return nil, fmt.Errorf("Duplicate sink name: %s; set a unique Name", config.Name)
+			}
+		}
+		if config.QueueSize <= 0 {
+			config.QueueSize = DefaultSinkQueueSize
+		}
+		if config.SampleQueueSize <= 0 {
+			config.SampleQueueSize = DefaultSinkSampleQueueSize
+		}
+		if config.Retries < 0 {
+			config.Retries = 0
+		} else if config.Retries == 0 {
+			config.Retries = DefaultSinkRetries
+		}
+		if config.RetryWait <= 0 {
+			config.RetryWait = DefaultSinkRetryWait
+		}
+		sink, err := NewSink(config)
+		if err != nil {
+			d.closeSinks()
+			return nil, err
+		}
+		o := &sinkOutput{
+			name:       config.Name,
+			sink:       sink,
+			config:     config,
+			queue:      make(chan sinkItem, config.QueueSize),
+			selfLabels: map[string]string{"sink": config.Name},
+		}
+		o.samples, _ = sink.(SampleSink)
+		d.outputs = append(d.outputs, o)
+		if config.Raw {
+			var ok bool
+			if o.raw, ok = sink.(RawSink); !ok {
+				d.closeSinks()
+				return nil, fmt.Errorf("Sink %s does not support Raw", config.Name)
+			}
+			d.rater = NewRater()
+		}
+		if o.samples != nil || o.raw != nil {
+			o.sampleQ = make(chan sinkItem, config.SampleQueueSize)
+		}
+		names = append(names, "sink-"+config.Name)
+	}
+	d.status = pct.NewStatus(names)
+	return d, nil
+}
+
+// Start starts a goroutine for every sink.
+func (d *Dispatcher) Start() {
+	for _, o := range d.outputs {
+		d.status.Update("sink-"+o.name, "Ready")
+		o.done = make(chan bool)
+		go d.run(o)
+	}
+}
+
+// Write queues the report for every sink.  It returns an error only if a
+// sink's queue was full so its oldest report was dropped; write errors are
+// in Status.
+func (d *Dispatcher) Write(service string, report *Report) error {
+	var full []string
+	for _, o := range d.outputs {
+		if !d.enqueue(o, sinkItem{report: report}) {
+			full = append(full, o.name)
+		}
+	}
+	if len(full) > 0 {
+		return fmt.Errorf("Sink queue full, dropped its oldest report: %v", full)
+	}
+	return nil
+}
+
+// WriteCollection queues the collection for every SampleSink and its
+// samples for every sink with Raw.
+func (d *Dispatcher) WriteCollection(c *Collection) error {
+	var samples []Sample
+	if d.rater != nil {
+		samples = d.rater.Samples(c)
+	}
+	for _, o := range d.outputs {
+		if o.samples != nil {
+			d.enqueue(o, sinkItem{collection: c})
+		}
+		if o.raw != nil && len(samples) > 0 {
+			d.enqueue(o, sinkItem{samples: samples})
+		}
+	}
+	return nil
+}
+
+// Close stops the sink goroutines, waiting a little for them to write what's
+// queued, then closes the sinks.  A sink still in a write when the wait is
+// over is closed when the write returns, not while it's writing.  Stop the
+// Aggregator first.
+func (d *Dispatcher) Close() {
+	close(d.stop)
+	deadline := time.Now().Add(dispatcherCloseTimeout)
+	for _, o := range d.outputs {
+		if o.done != nil {
+			select {
+			case <-o.done:
+			case <-time.After(time.Until(deadline)):
+				log.Warn(fmt.Sprintf("Timeout waiting for sink %s to finish writing", o.name))
+				go func(o *sinkOutput) {
+					<-o.done
+					o.sink.Close()
+				}(o)
+				continue
+			}
+		}
+		if n := len(o.queue); n > 0 {
+			log.Warn(fmt.Sprintf("Sink %s closed with %d reports not written", o.name, n))
+		}
+		if n := len(o.sampleQ); n > 0 {
+			log.Warn(fmt.Sprintf("Sink %s closed with %d collections or raw samples not written", o.name, n))
+		}
+		o.sink.Close()
+	}
+}
+
+// Reader returns the first sink that can be queried, or nil if none can.
+func (d *Dispatcher) Reader() Reader {
+	for _, o := range d.outputs {
+		if r, ok := o.sink.(Reader); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// RawReader returns the first sink with Raw that can query samples, or nil
+// if none can.
+func (d *Dispatcher) RawReader() RawReader {
+	for _, o := range d.outputs {
+		if r, ok := o.raw.(RawReader); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// Healthy returns an error if the last report write of a sink failed.
+func (d *Dispatcher) Healthy() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, o := range d.outputs {
+		if o.err != nil {
+			return fmt.Errorf("Sink %s failed: %s", o.name, o.err)
+		}
+	}
+	return nil
+}
+
+// Status returns the state of every sink keyed on "sink-<name>".
+func (d *Dispatcher) Status() map[string]string {
+	return d.status.All()
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Implementation
+/////////////////////////////////////////////////////////////////////////////
+
+// enqueue adds the item to the sink's report or sample queue, first dropping
+// the oldest item if the queue is full.  It returns false if an item was
+// dropped.  Only the Aggregator goroutine enqueues, so the second send can't
+// block.
+func (d *Dispatcher) enqueue(o *sinkOutput, item sinkItem) bool {
+	queue, length, dropped := o.queue, "self/sink/queue_length", "self/sink/dropped"
+	if item.report == nil {
+		queue, length, dropped = o.sampleQ, "self/sink/sample_queue_length", "self/sink/samples_dropped"
+	}
+	select {
+	case queue <- item:
+		Self.Set(length, o.selfLabels, float64(len(queue)))
+		return true
+	default:
+	}
+	select {
+	case <-queue:
+		Self.Inc(dropped, o.selfLabels)
+	default:
+	}
+	queue <- item
+	return false
+}
+
+func (d *Dispatcher) closeSinks() {
+	for _, o := range d.outputs {
+		o.sink.Close()
+	}
+}
+
+// @goroutine[1]
+func (d *Dispatcher) run(o *sinkOutput) {
+	defer close(o.done)
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error(fmt.Sprintf("Sink %s crashed: %s", o.name, err))
+			d.status.Update("sink-"+o.name, fmt.Sprintf("Crashed: %s", err))
+		}
+	}()
+
+	for {
+		// Reports first, then samples if there's no report to write.
+		select {
+		case item := <-o.queue:
+			Self.Set("self/sink/queue_length", o.selfLabels, float64(len(o.queue)))
+			d.writeItem(o, item)
+			continue
+		case <-d.stop:
+			d.drain(o)
+			return
+		default:
+		}
+		select {
+		case item := <-o.queue:
+			Self.Set("self/sink/queue_length", o.selfLabels, float64(len(o.queue)))
+			d.writeItem(o, item)
+		case item := <-o.sampleQ:
+			Self.Set("self/sink/sample_queue_length", o.selfLabels, float64(len(o.sampleQ)))
+			d.writeItem(o, item)
+		case <-d.stop:
+			d.drain(o)
+			return
+		}
+	}
+}
+
+// drain writes what's queued, e.g. the reports of Aggregator.Stop, reports
+// first.  Once stopped, write doesn't retry.
+// @goroutine[1]
+func (d *Dispatcher) drain(o *sinkOutput) {
+	for _, queue := range []chan sinkItem{o.queue, o.sampleQ} {
+		for len(queue) > 0 {
+			d.writeItem(o, <-queue)
+		}
+	}
+}
+
+// @goroutine[1]
+func (d *Dispatcher) writeItem(o *sinkOutput, item sinkItem) {
+	if item.collection != nil {
+		if err := o.samples.WriteCollection(item.collection); err != nil {
+			Self.Inc("self/storage/sample_errors", o.selfLabels)
+			log.Debug(fmt.Sprintf("Sink %s lost samples: %s", o.name, err))
+		}
+		return
+	}
+	if item.samples != nil {
+		if err := o.raw.WriteRaw(item.samples); err != nil {
+			Self.Inc("self/sink/raw_errors", o.selfLabels)
+			log.Debug(fmt.Sprintf("Sink %s lost raw samples: %s", o.name, err))
+		}
+		return
+	}
+	d.write(o, item.report)
+}
+
+// write writes the report, retrying per the sink config unless stopped.
+// @goroutine[1]
+func (d *Dispatcher) write(o *sinkOutput, report *Report) {
+	wait := time.Duration(o.config.RetryWait) * time.Second
+	for try := 0; ; try++ {
+		t0 := time.Now()
+		err := o.sink.Write("mm", report)
+		Self.Set("self/sink/write_seconds", o.selfLabels, time.Since(t0).Seconds())
+		if err == nil {
+			d.setErr(o, nil)
+			d.status.Update("sink-"+o.name, "Ok "+report.Ts.Format(time.RFC3339))
+			return
+		}
+		if _, noRetry := err.(ErrNoRetry); noRetry || try >= o.config.Retries {
+			d.lost(o, report, err)
+			return
+		}
+		d.status.Update("sink-"+o.name, fmt.Sprintf("Retrying in %s: %s", wait, err))
+		Self.Inc("self/sink/retries", o.selfLabels)
+		select {
+		case <-time.After(wait):
+		case <-d.stop:
+			d.lost(o, report, err)
+			return
+		}
+		wait *= 2
+	}
+}
+
+// lost records that the report won't be written.
+// @goroutine[1]
+func (d *Dispatcher) lost(o *sinkOutput, report *Report, err error) {
+	d.setErr(o, err)
+	log.Warn(fmt.Sprintf("Sink %s lost report %s: %s", o.name, report.Ts.Format(time.RFC3339), err))
+	Self.Inc("self/storage/write_errors", o.selfLabels)
+	d.status.Update("sink-"+o.name, "Error: "+err.Error())
+}
+
+func (d *Dispatcher) setErr(o *sinkOutput, err error) {
+	d.mux.Lock()
+	o.err = err
+	d.mux.Unlock()
+}
@@ -0,0 +1,160 @@
+package mm
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A failingSink fails the first fails writes with err.
+type failingSink struct {
+	mux         *sync.Mutex
+	fails       int
+	err         error
+	tries       int
+	written     []*Report
+	collections []*Collection
+}
+
+var failingSinks = map[string]*failingSink{}
+
+func init() {
+	RegisterSink("test", func(name string, data []byte) (Sink, error) {
+		s := &failingSink{mux: &sync.Mutex{}}
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, err
+		}
+		failingSinks[name] = s
+		return s, nil
+	})
+}
+
+func (s *failingSink) Write(service string, report *Report) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.tries++
+	if s.fails > 0 {
+		s.fails--
+		return s.err
+	}
+	s.written = append(s.written, report)
+	return nil
+}
+
+func (s *failingSink) WriteCollection(c *Collection) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.collections = append(s.collections, c)
+	return nil
+}
+
+func (s *failingSink) Close() {
+}
+
+func (s *failingSink) result() (int, int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.tries, len(s.written)
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	d, err := NewDispatcher([]SinkConfig{
+		{Type: "test", Name: "flaky", Config: json.RawMessage(`{}`), Retries: 2, RetryWait: 1},
+		{Type: "test", Name: "invalid", Config: json.RawMessage(`{}`), Retries: 2, RetryWait: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failingSinks["flaky"].fails = 1
+	failingSinks["flaky"].err = errors.New("timeout")
+	failingSinks["invalid"].fails = 1
+	failingSinks["invalid"].err = ErrNoRetry{Err: errors.New("bad request")}
+	d.Start()
+
+	d.Write("mm", &Report{Ts: time.Unix(0, 0)})
+	time.Sleep(1500 * time.Millisecond)
+
+	if tries, written := failingSinks["flaky"].result(); tries != 2 || written != 1 {
+		t.Errorf("flaky sink: %d tries, %d written; expected 2 tries, 1 written", tries, written)
+	}
+	if tries, written := failingSinks["invalid"].result(); tries != 1 || written != 0 {
+		t.Errorf("invalid sink: %d tries, %d written; expected 1 try, 0 written", tries, written)
+	}
+	if err := d.Healthy(); err == nil {
+		t.Error("Healthy after the invalid sink failed")
+	}
+
+	// Close writes what's queued, without retries.
+	failingSinks["flaky"].fails = 1
+	d.Write("mm", &Report{Ts: time.Unix(60, 0)})
+	d.Write("mm", &Report{Ts: time.Unix(120, 0)})
+	d.Close()
+	if tries, written := failingSinks["flaky"].result(); tries != 4 || written != 2 {
+		t.Errorf("flaky sink after Close: %d tries, %d written; expected 4 tries, 2 written", tries, written)
+	}
+	if tries, written := failingSinks["invalid"].result(); tries != 3 || written != 2 {
+		t.Errorf("invalid sink after Close: %d tries, %d written; expected 3 tries, 2 written", tries, written)
+	}
+}
+
+func TestDispatcherSampleQueue(t *testing.T) {
+	d, err := NewDispatcher([]SinkConfig{
+		{Type: "test", Name: "behind", Config: json.RawMessage(`{}`), QueueSize: 1, SampleQueueSize: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Collections arriving every tick while the sink is behind drop the
+	// oldest collections, not the report.
+	d.Write("mm", &Report{Ts: time.Unix(0, 0)})
+	for ts := int64(1); ts <= 10; ts++ {
+		d.WriteCollection(&Collection{Ts: ts})
+	}
+	d.Start()
+	d.Close()
+
+	s := failingSinks["behind"]
+	if len(s.written) != 1 {
+		t.Errorf("%d reports written, expected 1", len(s.written))
+	}
+	if len(s.collections) != 2 || s.collections[0].Ts != 9 || s.collections[1].Ts != 10 {
+		t.Errorf("got %d collections, expected the last 2", len(s.collections))
+	}
+}
+
+func TestDispatcherStopDuringRetry(t *testing.T) {
+	d, err := NewDispatcher([]SinkConfig{
+		{Type: "test", Name: "down", Config: json.RawMessage(`{}`), Retries: 2, RetryWait: 60},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failingSinks["down"].fails = 100
+	failingSinks["down"].err = errors.New("connection refused")
+	d.Start()
+
+	// Close ends the retry wait and counts the report as lost.
+	d.Write("mm", &Report{Ts: time.Unix(0, 0)})
+	time.Sleep(100 * time.Millisecond)
+	t0 := time.Now()
+	d.Close()
+	if time.Since(t0) > time.Second {
+		t.Errorf("Close took %s, expected it to end the retry wait", time.Since(t0))
+	}
+	if err := d.Healthy(); err == nil {
+		t.Error("Healthy after the report was lost")
+	}
+	key := MetricKey("self/storage/write_errors", map[string]string{"sink": "down"})
+	var lost float64
+	for _, m := range Self.Metrics() {
+		if m.Key() == key {
+			lost = m.Number
+		}
+	}
+	if lost != 1 {
+		t.Errorf("self/storage/write_errors = %.0f, expected 1", lost)
+	}
+}
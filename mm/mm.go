@@ -1,6 +1,8 @@
 package mm
 
 import (
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -14,19 +16,62 @@ type Metric struct {
 	Type   string // gauge, counter, string
 	Number float64
 	String string
+	Labels map[string]string // dimensions, e.g. schema=db1, table=t1
+}
+
+// Key returns a string that uniquely identifies the metric by its name and
+// labels, e.g. mysql/table/rows{schema=db1,table=t1}.
+func (m *Metric) Key() string {
+	return MetricKey(m.Name, m.Labels)
+}
+
+func MetricKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
 }
 
 type Collection struct {
-	Ts      int64 // UTC Unix timestamp
-	Metrics []Metric
+	Instance string // collector name, e.g. mysql
+	Ts       int64  // UTC Unix timestamp
+	Interval int64  // seconds between collections of these metrics, 0 = every tick
+	Metrics  []Metric
+	Events   []Event
+}
+
+// Event types.
+const (
+	EventRestart  = "restart"  // e.g. mysqld restarted
+	EventVariable = "variable" // e.g. a MySQL global variable changed
+)
+
+// An Event is something that happened to an instance at a point in time,
+// e.g. MySQL restarted.  Events aren't aggregated; they're stored as-is.
+type Event struct {
+	Ts       int64  // UTC Unix timestamp
+	Instance string // collector name, defaults to the collection's
+	Type     string // EventRestart, EventVariable, etc.
+	Text     string // human-readable, e.g. max_connections changed from 151 to 500
 }
 
 type InstanceStats struct {
-	Stats map[string]*Stats // keyed on metric name
+	Instance string
+	Stats    map[string]*Stats // keyed on Metric.Key()
 }
 
 type Report struct {
 	Ts       time.Time // start, UTC
 	Duration uint      // seconds
 	Stats    []*InstanceStats
+	Events   []Event
 }
@@ -0,0 +1,219 @@
+package mm
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Reader is storage that can query the reports written to it.
+type Reader interface {
+	// Read returns the stats of the metrics matching the query.
+	Read(q Query) ([]*Series, error)
+
+	// Names returns the distinct metric names of the instance, or of all
+	// instances if instance is empty, sorted.
+	Names(instance string) ([]string, error)
+
+	// Events returns the events from q.From to q.To sorted by Ts.  q.Name
+	// matches the event type; q.Resolution is ignored.
+	Events(q Query) ([]Event, error)
+}
+
+type Query struct {
+	Name       string    // metric name, * matches any characters, e.g. mysql/innodb/*
+	Instance   string    // empty matches all instances
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Resolution int64     // seconds per point, 0 = as reported
+}
+
+// NameRegexp returns the anchored regular expression for the Name pattern.
+func (q Query) NameRegexp() string {
+	parts := strings.Split(q.Name, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+// A Series is the points for one metric of one instance.
+type Series struct {
+	Instance string
+	Name     string
+	Labels   map[string]string `json:",omitempty"`
+	Points   []*Point
+}
+
+// A Point is the summary stats for one interval or, at a lower resolution,
+// several intervals.
+type Point struct {
+	Ts    time.Time // start, UTC
+	Cnt   int
+	Min   float64
+	Pct5  float64
+	Avg   float64
+	Med   float64
+	Pct95 float64
+	Max   float64
+}
+
+// Stat returns the summary stat by name: cnt, min, pct5, avg, med, pct95 or
+// max.  It returns false if there's no such stat.
+func (p *Point) Stat(name string) (float64, bool) {
+	switch name {
+	case "cnt":
+		return float64(p.Cnt), true
+	case "min":
+		return p.Min, true
+	case "pct5":
+		return p.Pct5, true
+	case "avg":
+		return p.Avg, true
+	case "med":
+		return p.Med, true
+	case "pct95":
+		return p.Pct95, true
+	case "max":
+		return p.Max, true
+	}
+	return 0, false
+}
+
+// A StoredValues is one metric of one report as read from storage.  Storage
+// that doesn't keep the values keeps their Summary instead.
+type StoredValues struct {
+	Ts       time.Time
+	Instance string
+	Name     string
+	Labels   map[string]string
+	Values   []float64
+	Summary  *Point // if no Values
+}
+
+// Rollup groups the stored values, which must be sorted by Ts, into series
+// and summarizes the values of each series in points of resolution seconds.
+// Resolution 0 makes one point per stored report.  Stats carried forward
+// (a Summary with Cnt=0) aren't values, so they only fill forward: a point
+// that has no values is the last carried stats, with Cnt=0.  Summaries of
+// several reports are merged: Cnt, Min and Max are exact but the
+// percentiles are averages weighted by Cnt, so they're only an estimate.
+func Rollup(stored []StoredValues, resolution int64) []*Series {
+	type bucket struct {
+		ts        time.Time
+		stats     *Stats
+		summaries []*Point
+		carried   *Point // last carried stats, if the bucket has no values
+	}
+	series := []*Series{}
+	buckets := map[string][]*bucket{}   // keyed on series key
+	seriesByKey := map[string]*Series{} // keyed on series key
+	for _, sv := range stored {
+		if len(sv.Values) == 0 && sv.Summary == nil {
+			continue // no values
+		}
+		key := sv.Instance + "\x00" + MetricKey(sv.Name, sv.Labels)
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &Series{
+				Instance: sv.Instance,
+				Name:     sv.Name,
+				Labels:   sv.Labels,
+			}
+			seriesByKey[key] = s
+			series = append(series, s)
+		}
+
+		ts := sv.Ts.UTC()
+		if resolution > 0 {
+			ts = time.Unix((ts.Unix()/resolution)*resolution, 0).UTC()
+		}
+		bs := buckets[key]
+		var b *bucket
+		if len(bs) > 0 && bs[len(bs)-1].ts.Equal(ts) {
+			b = bs[len(bs)-1]
+		} else {
+			stats, _ := NewStats("gauge") // values are already rates
+			b = &bucket{ts: ts, stats: stats}
+			buckets[key] = append(bs, b)
+		}
+		for _, v := range sv.Values {
+			b.stats.Add(&Metric{Number: v}, 0)
+		}
+		if len(sv.Values) == 0 {
+			if sv.Summary.Cnt > 0 {
+				b.summaries = append(b.summaries, sv.Summary)
+			} else {
+				b.carried = sv.Summary
+			}
+		}
+	}
+
+	for key, s := range seriesByKey {
+		for _, b := range buckets[key] {
+			points := b.summaries
+			if final := b.stats.Finalize(); final != nil {
+				points = append(points, final.Point())
+			}
+			p := mergePoints(points)
+			if p == nil && b.carried != nil {
+				carried := *b.carried
+				p = &carried
+			}
+			if p != nil {
+				p.Ts = b.ts
+				s.Points = append(s.Points, p)
+			}
+		}
+	}
+
+	sort.Sort(byInstanceAndKey(series))
+	return series
+}
+
+// mergePoints returns one point summarizing the points, or nil if there are
+// none.
+func mergePoints(points []*Point) *Point {
+	switch len(points) {
+	case 0:
+		return nil
+	case 1:
+		p := *points[0]
+		return &p
+	}
+	m := &Point{
+		Min: points[0].Min,
+		Max: points[0].Max,
+	}
+	for _, p := range points {
+		m.Cnt += p.Cnt
+		m.Min = math.Min(m.Min, p.Min)
+		m.Max = math.Max(m.Max, p.Max)
+		w := float64(p.Cnt)
+		m.Pct5 += p.Pct5 * w
+		m.Avg += p.Avg * w
+		m.Med += p.Med * w
+		m.Pct95 += p.Pct95 * w
+	}
+	if m.Cnt > 0 {
+		n := float64(m.Cnt)
+		m.Pct5 /= n
+		m.Avg /= n
+		m.Med /= n
+		m.Pct95 /= n
+	}
+	return m
+}
+
+type byInstanceAndKey []*Series
+
+func (s byInstanceAndKey) Len() int      { return len(s) }
+func (s byInstanceAndKey) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byInstanceAndKey) Less(i, j int) bool {
+	if s[i].Instance != s[j].Instance {
+		return s[i].Instance < s[j].Instance
+	}
+	return MetricKey(s[i].Name, s[i].Labels) < MetricKey(s[j].Name, s[j].Labels)
+}
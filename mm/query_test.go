@@ -0,0 +1,70 @@
+package mm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollupCarriedStats(t *testing.T) {
+	// A metric collected every 300s is reported every 60s: once with its
+	// value, then carried forward four times.
+	s, _ := NewStats("gauge")
+	s.Name = "mysql/table/rows"
+	s.SetInterval(300)
+	s.Add(&Metric{Name: s.Name, Type: "gauge", Number: 10}, 0)
+	stored := []StoredValues{}
+	for ts := int64(0); ts < 300; ts += 60 {
+		final := s.Finalize()
+		if final == nil {
+			final = s.Carry(ts, 60)
+		}
+		if final == nil {
+			t.Fatalf("no stats at %d", ts)
+		}
+		if ts > 0 && (!final.Carried || final.Cnt != 0 || final.Vals != nil || final.Avg != 10) {
+			t.Errorf("carried stats at %d: %+v, expected Carried, Cnt=0, no Vals and Avg=10", ts, final)
+		}
+		stored = append(stored, StoredValues{
+			Ts:      time.Unix(ts, 0),
+			Name:    final.Name,
+			Values:  final.Vals,
+			Summary: &Point{Cnt: final.Cnt, Min: final.Min, Avg: final.Avg, Max: final.Max},
+		})
+		s.Reset()
+	}
+	if c := s.Carry(300, 60); c != nil {
+		t.Errorf("carried stats at 300 when the next value is due: %+v", c)
+	}
+
+	// Carried stats aren't counted again in a point with the value.
+	series := Rollup(stored, 300)
+	if len(series) != 1 || len(series[0].Points) != 1 {
+		t.Fatalf("got %+v, expected 1 series with 1 point", series)
+	}
+	if p := series[0].Points[0]; p.Cnt != 1 || p.Avg != 10 {
+		t.Errorf("got Cnt=%d Avg=%f, expected Cnt=1 Avg=10", p.Cnt, p.Avg)
+	}
+
+	// But they fill forward the points without values, also from storage
+	// with only summaries, e.g. without KeepValues.
+	for _, values := range []bool{true, false} {
+		if !values {
+			for i := range stored {
+				stored[i].Values = nil
+			}
+		}
+		series = Rollup(stored, 60)
+		if len(series) != 1 || len(series[0].Points) != 5 {
+			t.Fatalf("got %+v, expected 1 series with 5 points", series)
+		}
+		for i, p := range series[0].Points {
+			cnt := 0
+			if i == 0 {
+				cnt = 1
+			}
+			if p.Ts.Unix() != int64(i*60) || p.Cnt != cnt || p.Avg != 10 {
+				t.Errorf("point %d: got Ts=%d Cnt=%d Avg=%f, expected Ts=%d Cnt=%d Avg=10", i, p.Ts.Unix(), p.Cnt, p.Avg, i*60, cnt)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package mm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Queue drop policies: which collection is dropped when the queue is full.
+const (
+	DropOldest = "drop-oldest"
+	DropNewest = "drop-newest"
+)
+
+// How often to warn that the queue is full.
+const queueFullWarnInterval = time.Minute
+
+// How long Stop waits for the Aggregator to receive the buffered collections.
+const queueStopTimeout = 10 * time.Second
+
+// A Queue is a bounded buffer between collectors and the Aggregator.
+// Collectors send to In(), which never blocks for long because the queue
+// always receives; the Aggregator receives from Out().  When the queue is
+// full, the oldest or newest collection is dropped so slow aggregation or
+// storage doesn't stall collection.
+type Queue struct {
+	size     int
+	policy   string
+	in       chan *Collection
+	out      chan *Collection
+	stopChan chan bool
+	doneChan chan bool
+	length   int64
+	dropped  uint64
+}
+
+func NewQueue(size int, policy string) (*Queue, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("Invalid queue size: %d", size)
+	}
+	switch policy {
+	case DropOldest, DropNewest:
+	default:
+		return nil, fmt.Errorf("Invalid queue policy: %s; expected %s or %s", policy, DropOldest, DropNewest)
+	}
+	q := &Queue{
+		size:   size,
+		policy: policy,
+		in:     make(chan *Collection),
+		out:    make(chan *Collection),
+	}
+	return q, nil
+}
+
+func (q *Queue) In() chan *Collection {
+	return q.in
+}
+
+func (q *Queue) Out() chan *Collection {
+	return q.out
+}
+
+func (q *Queue) Start() {
+	q.stopChan = make(chan bool)
+	q.doneChan = make(chan bool)
+	go q.run()
+}
+
+// Stop sends the buffered collections to Out(), then stops.  Stop the
+// collectors first, and the Aggregator after, so it reports them.
+func (q *Queue) Stop() {
+	close(q.stopChan)
+	<-q.doneChan
+}
+
+// Len returns the number of collections in the queue.
+func (q *Queue) Len() int {
+	return int(atomic.LoadInt64(&q.length))
+}
+
+func (q *Queue) Size() int {
+	return q.size
+}
+
+func (q *Queue) Policy() string {
+	return q.policy
+}
+
+// Dropped returns how many collections were dropped because the queue was full.
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// @goroutine[1]
+func (q *Queue) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Queue crashed: ", err)
+		}
+		close(q.doneChan)
+	}()
+
+	buf := make([]*Collection, 0, q.size)
+	var lastWarn time.Time
+	for {
+		// Only try to send when there's something to send; a nil chan
+		// blocks forever so that case is ignored.
+		var out chan *Collection
+		var next *Collection
+		if len(buf) > 0 {
+			out = q.out
+			next = buf[0]
+		}
+
+		select {
+		case c := <-q.in:
+			if len(buf) < q.size {
+				buf = append(buf, c)
+			} else {
+				n := atomic.AddUint64(&q.dropped, 1)
+				if q.policy == DropOldest {
+					buf = append(buf[1:], c)
+				}
+				if time.Since(lastWarn) >= queueFullWarnInterval {
+					log.Warn(fmt.Sprintf("Collection queue is full (%d); the aggregator or storage is too slow."+
+						" %d collections dropped (%s)", q.size, n, q.policy))
+					lastWarn = time.Now()
+				}
+			}
+		case out <- next:
+			buf = buf[1:]
+		case <-q.stopChan:
+			q.drain(buf)
+			return
+		}
+		atomic.StoreInt64(&q.length, int64(len(buf)))
+	}
+}
+
+// drain sends the collections to Out() unless the Aggregator doesn't
+// receive them in time, e.g. it crashed.
+// @goroutine[1]
+func (q *Queue) drain(buf []*Collection) {
+	timeout := time.After(queueStopTimeout)
+	for len(buf) > 0 {
+		select {
+		case q.out <- buf[0]:
+			buf = buf[1:]
+			atomic.StoreInt64(&q.length, int64(len(buf)))
+		case <-timeout:
+			log.Warn(fmt.Sprintf("Timeout sending collections to the aggregator; %d not aggregated", len(buf)))
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package mm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueStopDrains(t *testing.T) {
+	q, err := NewQueue(10, DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start()
+
+	// The collectors send the last collections of the interval while the
+	// aggregator isn't receiving, so the queue buffers them.
+	start := (time.Now().Unix() / 60) * 60
+	for i := int64(0); i < 3; i++ {
+		q.In() <- collection(start+i, float64(i))
+	}
+	for q.Len() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Shut down like main: the buffered collections are in the last report.
+	sink := &testSink{reports: make(chan *Report, 10)}
+	a := NewAggregator(60, 5, q.Out(), sink)
+	a.Start()
+	q.Stop()
+	a.Stop()
+	select {
+	case r := <-sink.reports:
+		if s := r.Stats[0].Stats["threads_running"]; s.Cnt != 3 {
+			t.Errorf("last report Cnt=%d, expected 3", s.Cnt)
+		}
+	default:
+		t.Fatal("no report of the buffered collections")
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("%d collections left in the queue", n)
+	}
+}
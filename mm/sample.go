@@ -0,0 +1,83 @@
+package mm
+
+import (
+	"math"
+)
+
+// A Sample is one collected value, not aggregated.  Counters also have their
+// per-second rate since the previous value.
+type Sample struct {
+	Ts       int64 // UTC Unix timestamp
+	Instance string
+	Name     string
+	Labels   map[string]string `json:",omitempty"`
+	Type     string            // gauge or counter
+	Value    float64           // as collected, e.g. the counter total
+	Rate     float64           // counters only; NaN for the first value and after a reset
+}
+
+// A RawSink is a Sink that also stores the raw samples of every collection
+// when its SinkConfig.Raw is set.  WriteRaw is called from the sink's
+// Dispatcher goroutine.
+type RawSink interface {
+	Sink
+	WriteRaw(samples []Sample) error
+}
+
+// A RawReader is a RawSink that can query the samples written to it.
+type RawReader interface {
+	// ReadRaw returns the samples of the metrics matching the query sorted
+	// by Ts; q.Resolution is ignored.
+	ReadRaw(q Query) ([]Sample, error)
+}
+
+// A Rater makes the samples of collections, computing counter rates from the
+// previous value of each counter.  It's not safe for concurrent use.
+type Rater struct {
+	last map[string]rateValue // keyed on instance and metric key
+}
+
+type rateValue struct {
+	ts    int64
+	value float64
+}
+
+func NewRater() *Rater {
+	r := &Rater{
+		last: make(map[string]rateValue),
+	}
+	return r
+}
+
+// Samples returns the samples of the collection's gauges and counters.
+// Collections of an instance must be given in time order, else the rates
+// of out-of-order collections are NaN.
+func (r *Rater) Samples(c *Collection) []Sample {
+	samples := make([]Sample, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		if !MetricTypes[m.Type] {
+			continue
+		}
+		s := Sample{
+			Ts:       c.Ts,
+			Instance: c.Instance,
+			Name:     m.Name,
+			Labels:   m.Labels,
+			Type:     m.Type,
+			Value:    m.Number,
+			Rate:     math.NaN(),
+		}
+		if m.Type == "counter" {
+			key := c.Instance + "\x00" + m.Key()
+			last, ok := r.last[key]
+			if ok && c.Ts > last.ts && m.Number >= last.value {
+				s.Rate = (m.Number - last.value) / float64(c.Ts-last.ts)
+			}
+			if !ok || c.Ts > last.ts {
+				r.last[key] = rateValue{ts: c.Ts, value: m.Number}
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
@@ -0,0 +1,158 @@
+package mm
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+)
+
+// SelfStats are metrics about the metrics collector itself which components
+// update as they work, e.g. query errors and write latency.
+type SelfStats struct {
+	metrics map[string]*Metric // keyed on Metric.Key()
+	mux     *sync.Mutex
+}
+
+// Self is where every component records its self metrics.
+var Self = NewSelfStats()
+
+func NewSelfStats() *SelfStats {
+	s := &SelfStats{
+		metrics: make(map[string]*Metric),
+		mux:     &sync.Mutex{},
+	}
+	return s
+}
+
+// Inc increments a counter.
+func (s *SelfStats) Inc(name string, labels map[string]string) {
+	s.update(name, "counter", labels, func(m *Metric) { m.Number++ })
+}
+
+// Set sets a gauge.
+func (s *SelfStats) Set(name string, labels map[string]string, value float64) {
+	s.update(name, "gauge", labels, func(m *Metric) { m.Number = value })
+}
+
+// Metrics returns a copy of every metric.
+func (s *SelfStats) Metrics() []Metric {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	metrics := make([]Metric, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		metrics = append(metrics, *m)
+	}
+	return metrics
+}
+
+func (s *SelfStats) update(name, metricType string, labels map[string]string, f func(*Metric)) {
+	key := MetricKey(name, labels)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	m, ok := s.metrics[key]
+	if !ok {
+		m = &Metric{Name: name, Type: metricType, Labels: labels}
+		s.metrics[key] = m
+	}
+	f(m)
+}
+
+// SelfCollector collects metrics about the metrics collector itself: Self,
+// the queue, and the Go runtime.
+type SelfCollector struct {
+	name           string
+	queue          *Queue
+	tickChan       <-chan time.Time
+	collectionChan chan *Collection
+	stopChan       chan bool
+	doneChan       chan bool
+	status         *pct.Status
+}
+
+func NewSelfCollector(name string, queue *Queue) *SelfCollector {
+	s := &SelfCollector{
+		name:   name,
+		queue:  queue,
+		status: pct.NewStatus([]string{name}),
+	}
+	return s
+}
+
+func (s *SelfCollector) Name() string {
+	return s.name
+}
+
+func (s *SelfCollector) Start(tickChan <-chan time.Time, collectionChan chan *Collection) error {
+	s.tickChan = tickChan
+	s.collectionChan = collectionChan
+	s.stopChan = make(chan bool)
+	s.doneChan = make(chan bool)
+	go s.run()
+	return nil
+}
+
+func (s *SelfCollector) Stop() error {
+	close(s.stopChan)
+	<-s.doneChan
+	return nil
+}
+
+func (s *SelfCollector) Status() map[string]string {
+	return s.status.All()
+}
+
+// @goroutine[1]
+func (s *SelfCollector) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("Self collector crashed: ", err)
+		}
+		s.status.Update(s.name, "Stopped")
+		close(s.doneChan)
+	}()
+
+	s.status.Update(s.name, "Running")
+	for {
+		select {
+		case now := <-s.tickChan:
+			c := &Collection{
+				Instance: s.name,
+				Ts:       now.UTC().Unix(),
+				Metrics:  s.metrics(),
+			}
+			select {
+			case s.collectionChan <- c:
+			case <-time.After(500 * time.Millisecond):
+				log.Debug("Lost self metrics; timeout spooling after 500ms")
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+func (s *SelfCollector) metrics() []Metric {
+	metrics := Self.Metrics()
+
+	if s.queue != nil {
+		labels := map[string]string{"policy": s.queue.Policy()}
+		metrics = append(metrics,
+			Metric{"self/queue/length", "gauge", float64(s.queue.Len()), "", nil},
+			Metric{"self/queue/size", "gauge", float64(s.queue.Size()), "", nil},
+			Metric{"self/queue/dropped", "counter", float64(s.queue.Dropped()), "", labels},
+		)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	metrics = append(metrics,
+		Metric{"self/runtime/goroutines", "gauge", float64(runtime.NumGoroutine()), "", nil},
+		Metric{"self/runtime/heap_alloc_bytes", "gauge", float64(mem.HeapAlloc), "", nil},
+		Metric{"self/runtime/heap_sys_bytes", "gauge", float64(mem.HeapSys), "", nil},
+		Metric{"self/runtime/gc", "counter", float64(mem.NumGC), "", nil},
+	)
+	return metrics
+}
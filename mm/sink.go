@@ -0,0 +1,99 @@
+package mm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A Sink is where the Aggregator writes reports, e.g. MongoDB or InfluxDB.
+// Write is called from one goroutine; it should return an error if the
+// report was not written so the failure is counted and reported.  Write
+// tries once: the Dispatcher retries failed reports per the SinkConfig.
+type Sink interface {
+	Write(service string, report *Report) error
+	Close()
+}
+
+// ErrNoRetry is a write error that retrying won't fix, e.g. the storage
+// rejected the report as invalid, so the Dispatcher doesn't retry it.
+type ErrNoRetry struct {
+	Err error
+}
+
+func (e ErrNoRetry) Error() string {
+	return e.Err.Error()
+}
+
+// A SampleSink is a Sink that also takes every collection as it arrives,
+// before aggregation, e.g. to send per-tick values to StatsD.
+// WriteCollection is called from the Aggregator goroutine, so it must not
+// block for long.
+type SampleSink interface {
+	Sink
+	WriteCollection(c *Collection) error
+}
+
+// A SinkFactory makes a Sink from its JSON config, which is empty if the
+// sink has no config.
+type SinkFactory func(name string, config []byte) (Sink, error)
+
+type SinkConfig struct {
+	Type   string          // mongo, influxdb, etc.
+	Name   string          // unique, defaults to Type
+	Config json.RawMessage // sink-specific
+
+	// How the Dispatcher feeds the sink; zero values are the defaults.
+	QueueSize       int   // reports buffered, default 100; the oldest is dropped when full
+	SampleQueueSize int   // collections and raw samples buffered, default 1000; likewise
+	Retries         int   // times a failed report is written again, default 2, -1 = none
+	RetryWait       int64 // seconds before the first retry, doubled every retry, default 5
+	Raw             bool  // also write the raw samples of every collection; the sink must be a RawSink
+}
+
+var (
+	sinkFactoriesMux = &sync.Mutex{}
+	sinkFactories    = make(map[string]SinkFactory)
+)
+
+// RegisterSink makes a sink type available to NewSink.  It's usually called
+// from the init() of the package implementing the sink.
+func RegisterSink(sinkType string, factory SinkFactory) {
+	sinkFactoriesMux.Lock()
+	defer sinkFactoriesMux.Unlock()
+	if _, ok := sinkFactories[sinkType]; ok {
+		panic("mm: RegisterSink called twice for " + sinkType)
+	}
+	sinkFactories[sinkType] = factory
+}
+
+// SinkTypes returns the registered sink types, sorted.
+func SinkTypes() []string {
+	sinkFactoriesMux.Lock()
+	defer sinkFactoriesMux.Unlock()
+	types := []string{}
+	for t := range sinkFactories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// NewSink makes a sink from the config.
+func NewSink(config SinkConfig) (Sink, error) {
+	if config.Name == "" {
+		config.Name = config.Type
+	}
+	sinkFactoriesMux.Lock()
+	factory, ok := sinkFactories[config.Type]
+	sinkFactoriesMux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown sink type: %s", config.Type)
+	}
+	s, err := factory(config.Name, config.Config)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s sink config: %s", config.Name, err)
+	}
+	return s, nil
+}
@@ -40,12 +40,26 @@ func (e ErrValueLap) Error() string {
 }
 
 type Stats struct {
+	Name   string
+	Labels map[string]string `json:",omitempty"`
+	Type   string            // gauge or counter
+	Last   float64           // last value added, e.g. the counter total
+	Since  int64             // Unix ts the counter total counts from: first value or last reset
+
+	// Carried stats repeat the last stats of a metric collected less often
+	// than the aggregation interval, in the intervals between its values.
+	// Cnt is 0 because the interval had no values.
+	Carried bool `json:",omitempty"`
+
 	metricType string    `json:"-"` // ignore
 	str        string    `json:",omitempty"`
 	firstVal   bool      `json:"-"`
 	prevTs     int64     `json:"-"`
 	penuTs     int64     `json:"-"`
 	prevVal    float64   `json:"-"` // last value
+	interval   int64     `json:"-"` // seconds between values, 0 = every tick
+	lastTs     int64     `json:"-"` // ts of last value added
+	last       *Stats    `json:"-"` // last finalized stats
 	penuVal    float64   `json:"-"` // 2nd to last (penultimate) value
 	Vals       []float64 `json:"-"`
 	sum        float64   `json:"-"`
@@ -63,6 +77,7 @@ func NewStats(metricType string) (*Stats, error) {
 		return nil, fmt.Errorf("Invalid metric type: %s", metricType)
 	}
 	s := &Stats{
+		Type:       metricType,
 		metricType: metricType,
 		Vals:       []float64{},
 		firstVal:   true,
@@ -116,6 +131,7 @@ func (s *Stats) Add(m *Metric, ts int64) error {
 				s.prevVal = m.Number
 			} else {
 				// Metric value reset, e.g. FLUSH GLOBAL STATUS.
+				s.Since = ts
 				s.penuTs = s.prevTs
 				s.prevTs = ts
 				s.penuVal = s.prevVal
@@ -127,21 +143,30 @@ func (s *Stats) Add(m *Metric, ts int64) error {
 			s.penuVal = s.prevVal
 			s.prevVal = m.Number
 			s.firstVal = false
+			s.Since = ts
 		}
 	default:
 		// This should not happen because type is checked in NewStats().
 		log.Panic("mm:Aggregator:Add: Invalid metric type: " + s.metricType)
 	}
+	s.lastTs = ts
+	s.Last = m.Number
 	return err
 }
 
-func (s *Stats) Finalize() *Stats {
-	if len(s.Vals) == 0 {
-		return nil
-	}
-	s.Summarize()
-	return &Stats{
-		Vals:  s.Vals,
+// SetInterval sets how often, in seconds, values for the metric are collected.
+func (s *Stats) SetInterval(interval int64) {
+	s.interval = interval
+}
+
+// Stat returns the summary stat by name like Point.Stat.
+func (s *Stats) Stat(name string) (float64, bool) {
+	return s.Point().Stat(name)
+}
+
+// Point returns the summary stats as a Point without a Ts.
+func (s *Stats) Point() *Point {
+	return &Point{
 		Cnt:   s.Cnt,
 		Min:   s.Min,
 		Pct5:  s.Pct5,
@@ -152,6 +177,51 @@ func (s *Stats) Finalize() *Stats {
 	}
 }
 
+func (s *Stats) Finalize() *Stats {
+	if len(s.Vals) == 0 {
+		return nil
+	}
+	s.Summarize()
+	s.last = &Stats{
+		Name:   s.Name,
+		Labels: s.Labels,
+		Type:   s.Type,
+		Last:   s.Last,
+		Since:  s.Since,
+		Vals:   s.Vals,
+		Cnt:    s.Cnt,
+		Min:    s.Min,
+		Pct5:   s.Pct5,
+		Avg:    s.Avg,
+		Med:    s.Med,
+		Pct95:  s.Pct95,
+		Max:    s.Max,
+	}
+	return s.last
+}
+
+// Carry returns the last finalized stats for a metric that had no values in
+// the interval starting at ts, or nil.  Metrics collected less often than the
+// aggregation interval are expected to be missing from most intervals, so
+// their last stats are carried forward until the next value is due: sinks
+// write them like other stats, so the metric has a value in every report.
+// The carried stats have no Vals and Cnt=0 because the interval had no
+// values, so they aren't counted again.
+func (s *Stats) Carry(ts, aggInterval int64) *Stats {
+	if s.last == nil || s.interval <= aggInterval {
+		return nil
+	}
+	if ts >= s.lastTs+s.interval {
+		// Next value is overdue; don't report stale stats.
+		return nil
+	}
+	carried := *s.last
+	carried.Vals = nil
+	carried.Cnt = 0
+	carried.Carried = true
+	return &carried
+}
+
 func (s *Stats) Summarize() {
 	switch s.metricType {
 	case "gauge", "counter":
@@ -3,10 +3,13 @@ package mysql
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os/exec"
 	"os/user"
 	"path"
 	"strings"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type DSN struct {
@@ -143,3 +146,52 @@ func HideDSNPassword(dsn string) string {
 	userPasswordParts := strings.Split(userPart, ":")
 	return userPasswordParts[0] + ":" + HiddenPassword + "@" + hostPart
 }
+
+// LocalDSN returns true if the DSN connects to a server on this host: over a
+// Unix socket, or over TCP to a loopback or local interface address.  A DSN
+// without an address connects to 127.0.0.1.  An error means it can't tell,
+// e.g. the host name didn't resolve, so the caller should ask again later.
+func LocalDSN(dsn string) (bool, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return false, err
+	}
+	switch cfg.Net {
+	case "unix":
+		return true, nil
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return false, nil // custom dialer, don't know where it goes
+	}
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		host = cfg.Addr
+	}
+	if host == "" {
+		return true, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false, err
+	}
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, err
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() && !hasIP(local, ip) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func hasIP(addrs []net.Addr, ip net.IP) bool {
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package mysql
+
+import (
+	"testing"
+)
+
+func TestLocalDSN(t *testing.T) {
+	tests := []struct {
+		dsn   string
+		local bool
+	}{
+		{"/", true},
+		{"root@unix(/var/run/mysqld/mysqld.sock)/", true},
+		{"root:pass@tcp(127.0.0.1:3306)/?parseTime=true", true},
+		{"root:pass@tcp([::1]:3306)/", true},
+		{"root:pass@tcp(192.0.2.1:3306)/", false},
+		{"root:pass@tcp(192.0.2.1)/", false},
+		// A password or param with "@" or "/" doesn't move the address.
+		{"root:p@ss/w@rd@tcp(192.0.2.1:3306)/", false},
+		{"root:pass@tcp(192.0.2.1:3306)/?loc=America%2FNew_York", false},
+		{"root:pass@tcp(192.0.2.1:3306)/db?tls=skip-verify&parseTime=true", false},
+	}
+	for _, test := range tests {
+		local, err := LocalDSN(test.dsn)
+		if err != nil {
+			t.Errorf("%s: %s", test.dsn, err)
+			continue
+		}
+		if local != test.local {
+			t.Errorf("%s: got local=%t, expected %t", test.dsn, local, test.local)
+		}
+	}
+
+	if _, err := LocalDSN("root:pass@tcp(no-such-host.invalid:3306)/"); err == nil {
+		t.Error("no error for a host that doesn't resolve")
+	}
+}
@@ -31,4 +31,5 @@ const (
 	ER_SPECIFIC_ACCESS_DENIED_ERROR = 1227
 	ER_SYNTAX_ERROR                 = 1064
 	ER_USER_DENIED                  = 1142
+	ER_QUERY_TIMEOUT                = 3024 // MAX_EXECUTION_TIME exceeded
 )
@@ -1,9 +1,11 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,21 +19,33 @@ type Query struct {
 	Expect string // 0
 }
 
+// A Connector is a shared connection to MySQL.  The Context methods stop
+// when ctx is canceled or, if a query timeout is set, when the timeout
+// expires, whichever is first; the other methods are the same with
+// context.Background().
 type Connector interface {
 	DB() *sql.DB
 	DSN() string
 	Connect(tries uint) error
+	ConnectContext(ctx context.Context, tries uint) error
 	Close()
 	Set([]Query) error
+	SetContext(ctx context.Context, queries []Query) error
 	GetGlobalVarString(varName string) string
+	GetGlobalVarStringContext(ctx context.Context, varName string) string
 	GetGlobalVarNumber(varName string) float64
+	GetGlobalVarNumberContext(ctx context.Context, varName string) float64
 	Uptime() (uptime int64, err error)
+	UptimeContext(ctx context.Context) (uptime int64, err error)
+	BackoffWait() time.Duration
+	SetQueryTimeout(timeout time.Duration)
 }
 
 type Connection struct {
 	dsn             string
 	conn            *sql.DB
 	backoff         *pct.Backoff
+	queryTimeout    time.Duration // per query and ping, 0 = none
 	connectedAmount uint
 	connectionMux   *sync.Mutex
 }
@@ -53,43 +67,91 @@ func (c *Connection) DSN() string {
 	return c.dsn
 }
 
-func (c *Connection) Connect(tries uint) error {
-	if tries == 0 {
-		return nil
-	}
+// BackoffWait returns how long the last connect attempt waited.
+func (c *Connection) BackoffWait() time.Duration {
 	c.connectionMux.Lock()
 	defer c.connectionMux.Unlock()
-	if c.connectedAmount > 0 {
-		// already have opened connection
-		c.connectedAmount++
-		return nil
+	return c.backoff.Last()
+}
+
+// SetQueryTimeout sets how long each query and connect ping may take.  It's
+// also the MAX_EXECUTION_TIME hint of the SELECTs.  0 disables the timeout.
+func (c *Connection) SetQueryTimeout(timeout time.Duration) {
+	c.connectionMux.Lock()
+	defer c.connectionMux.Unlock()
+	c.queryTimeout = timeout
+}
+
+// withTimeout returns ctx with the query timeout, if any.
+func (c *Connection) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	c.connectionMux.Lock()
+	timeout := c.queryTimeout
+	c.connectionMux.Unlock()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (c *Connection) Connect(tries uint) error {
+	return c.ConnectContext(context.Background(), tries)
+}
+
+// ConnectContext connects to MySQL, waiting the backoff before every try.
+// connectionMux isn't held while waiting or pinging, so the other methods
+// don't block for that long.
+func (c *Connection) ConnectContext(ctx context.Context, tries uint) error {
 	var err error
-	var db *sql.DB
 	for i := tries; i > 0; i-- {
+		c.connectionMux.Lock()
+		if c.connectedAmount > 0 {
+			// already have opened connection
+			c.connectedAmount++
+			c.connectionMux.Unlock()
+			return nil
+		}
+		wait := c.backoff.Wait()
+		c.connectionMux.Unlock()
+
 		// Wait before attempt.
-		time.Sleep(c.backoff.Wait())
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 
 		// Open connection to MySQL but...
+		var db *sql.DB
 		db, err = sql.Open("mysql", c.dsn)
 		if err != nil {
 			continue
 		}
 
 		// ...try to use the connection for real.
-		if err = db.Ping(); err != nil {
+		pingCtx, cancel := c.withTimeout(ctx)
+		err = db.PingContext(pingCtx)
+		cancel()
+		if err != nil {
 			// Connection failed.  Wrong username or password?
 			db.Close()
 			continue
 		}
 
-		// Connected
-		c.conn = db
-		c.backoff.Success()
+		// Connected, unless another caller connected first.
+		c.connectionMux.Lock()
+		if c.connectedAmount > 0 {
+			db.Close()
+		} else {
+			c.conn = db
+			c.backoff.Success()
+		}
 		c.connectedAmount++
+		c.connectionMux.Unlock()
+		return nil
+	}
+	if tries == 0 {
 		return nil
 	}
-
 	return fmt.Errorf("Cannot connect to MySQL %s: %s", HideDSNPassword(c.dsn), FormatError(err))
 }
 
@@ -108,17 +170,24 @@ func (c *Connection) Close() {
 }
 
 func (c *Connection) Set(queries []Query) error {
+	return c.SetContext(context.Background(), queries)
+}
+
+func (c *Connection) SetContext(ctx context.Context, queries []Query) error {
 	if c.conn == nil {
 		return errors.New("Not connected")
 	}
 	for _, query := range queries {
 		if query.Set != "" {
-			if _, err := c.conn.Exec(query.Set); err != nil {
+			queryCtx, cancel := c.withTimeout(ctx)
+			_, err := c.conn.ExecContext(queryCtx, query.Set)
+			cancel()
+			if err != nil {
 				return err
 			}
 		}
 		if query.Verify != "" {
-			got := c.GetGlobalVarString(query.Verify)
+			got := c.GetGlobalVarStringContext(ctx, query.Verify)
 			if got != query.Expect {
 				return fmt.Errorf(
 					"Global variable '%s' is set to '%s' but needs to be '%s'. "+
@@ -132,30 +201,75 @@ func (c *Connection) Set(queries []Query) error {
 }
 
 func (c *Connection) GetGlobalVarString(varName string) string {
+	return c.GetGlobalVarStringContext(context.Background(), varName)
+}
+
+func (c *Connection) GetGlobalVarStringContext(ctx context.Context, varName string) string {
 	if c.conn == nil {
 		return ""
 	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	var varValue string
-	c.conn.QueryRow("SELECT @@GLOBAL." + varName).Scan(&varValue)
+	c.conn.QueryRowContext(ctx, MaxExecutionTime(ctx, "SELECT @@GLOBAL."+varName)).Scan(&varValue)
 	return varValue
 }
 
 func (c *Connection) GetGlobalVarNumber(varName string) float64 {
+	return c.GetGlobalVarNumberContext(context.Background(), varName)
+}
+
+func (c *Connection) GetGlobalVarNumberContext(ctx context.Context, varName string) float64 {
 	if c.conn == nil {
 		return 0
 	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	var varValue float64
-	c.conn.QueryRow("SELECT @@GLOBAL." + varName).Scan(&varValue)
+	c.conn.QueryRowContext(ctx, MaxExecutionTime(ctx, "SELECT @@GLOBAL."+varName)).Scan(&varValue)
 	return varValue
 }
 
 func (c *Connection) Uptime() (uptime int64, err error) {
+	return c.UptimeContext(context.Background())
+}
+
+func (c *Connection) UptimeContext(ctx context.Context) (uptime int64, err error) {
 	if c.conn == nil {
 		return 0, fmt.Errorf("Error while getting Uptime(). Not connected to the db: %s", c.DSN())
 	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	// Result from SHOW STATUS includes two columns,
 	// Variable_name and Value, we ignore the first one as we need only Value
 	var varName string
-	c.conn.QueryRow("SHOW STATUS LIKE 'Uptime'").Scan(&varName, &uptime)
+	if err := c.conn.QueryRowContext(ctx, "SHOW STATUS LIKE 'Uptime'").Scan(&varName, &uptime); err != nil {
+		return 0, err
+	}
 	return uptime, nil
 }
+
+// MaxExecutionTime returns the query with a MAX_EXECUTION_TIME optimizer
+// hint of the time left until the ctx deadline, so MySQL 5.7.8+ stops the
+// query itself if the client gives up.  Only SELECTs can have the hint;
+// other queries, queries that already have it, and queries without a
+// deadline are returned as is.  Older versions and MariaDB ignore the hint
+// as a comment.
+func MaxExecutionTime(ctx context.Context, query string) string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return query
+	}
+	trimmed := strings.TrimLeft(query, " \t\r\n")
+	if len(trimmed) < 7 || !strings.EqualFold(trimmed[:6], "SELECT") || !strings.ContainsAny(trimmed[6:7], " \t\r\n") {
+		return query
+	}
+	if strings.Contains(strings.ToUpper(query), "MAX_EXECUTION_TIME") {
+		return query
+	}
+	ms := int64(time.Until(deadline) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return fmt.Sprintf("SELECT /*+ MAX_EXECUTION_TIME(%d) */%s", ms, trimmed[6:])
+}
@@ -1,6 +1,48 @@
 package mysqlCollector
 
 type Config struct {
-	Status map[string]string // SHOW STATUS variables to collect, case-sensitive
-	InnoDB []string          // SET GLOBAL innodb_monitor_enable="<value>"
+	DSN     string            // user:pass@tcp(host:port)/
+	Status  map[string]string // SHOW STATUS variables to collect, case-sensitive
+	InnoDB  []string          // SET GLOBAL innodb_monitor_enable="<value>"
+	Pid     int               // mysqld PID, 0 = find it from @@pid_file or @@socket
+	ProcDir string            // usually /proc
+	Queries []Query           // custom queries
+
+	// Collection intervals in seconds for each source; 0 disables the source.
+	StatusInterval      int64 // SHOW GLOBAL STATUS
+	InnoDBInterval      int64 // INFORMATION_SCHEMA.INNODB_METRICS
+	ProcesslistInterval int64 // INFORMATION_SCHEMA.PROCESSLIST
+	TableSizeInterval   int64 // INFORMATION_SCHEMA.TABLES
+	ReplicationInterval int64 // SHOW SLAVE STATUS
+	ProcessInterval     int64 // /proc/<mysqld pid>/
+	FilesystemInterval  int64 // statfs of @@datadir, @@tmpdir, etc.
+	EventsInterval      int64 // Uptime and SHOW GLOBAL VARIABLES for restart and variable events
+
+	// Milliseconds each source may take before its queries are canceled,
+	// also the MAX_EXECUTION_TIME hint of its SELECTs; 0 = no limit.
+	QueryTimeout int64
+}
+
+func DefaultConfig() *Config {
+	// Copy the status variables so config changes don't affect other collectors.
+	status := make(map[string]string, len(GlobalMySQLStatus))
+	for k, v := range GlobalMySQLStatus {
+		status[k] = v
+	}
+	c := &Config{
+		Status:              status,
+		InnoDB:              []string{"%"},
+		ProcDir:             "/proc",
+		StatusInterval:      1,
+		InnoDBInterval:      1,
+		ProcesslistInterval: 10,
+		TableSizeInterval:   300,
+		ReplicationInterval: 5,
+		ProcessInterval:     1,
+		FilesystemInterval:  60,
+		EventsInterval:      10,
+		// --
+		QueryTimeout: 5000,
+	}
+	return c
 }
@@ -0,0 +1,101 @@
+package mysqlCollector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Global variables that change without anyone setting them, so changes
+// aren't events.
+var volatileVariables = map[string]bool{
+	"gtid_executed": true,
+	"gtid_owned":    true,
+	"gtid_purged":   true,
+	"timestamp":     true,
+}
+
+// --------------------------------------------------------------------------
+// Restart and variable-change events
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) GetEvents(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetEvents:call")
+	defer log.Debug("GetEvents:return")
+
+	// Uptime going backwards means mysqld restarted since the last check.
+	var name string
+	var uptime int64
+	if err := conn.QueryRowContext(ctx, "SHOW /*!50002 GLOBAL */ STATUS LIKE 'Uptime'").Scan(&name, &uptime); err != nil {
+		return err
+	}
+	if uptime < m.uptime {
+		c.Events = append(c.Events, mm.Event{
+			Ts:       c.Ts - uptime,
+			Instance: m.name,
+			Type:     mm.EventRestart,
+			Text:     fmt.Sprintf("MySQL restarted (uptime was %ds)", m.uptime),
+		})
+	}
+	m.uptime = uptime
+
+	rows, err := conn.QueryContext(ctx, "SHOW /*!50002 GLOBAL */ VARIABLES")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	variables := make(map[string]string)
+	for rows.Next() {
+		var varName string
+		var varValue sql.NullString
+		if err = rows.Scan(&varName, &varValue); err != nil {
+			return err
+		}
+		varName = strings.ToLower(varName)
+		if volatileVariables[varName] {
+			continue
+		}
+		variables[varName] = varValue.String
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// The first time there's nothing to compare to.  After a restart, changes
+	// are still reported because they're usually my.cnf changes.
+	if m.variables != nil {
+		for _, varName := range changedVariables(m.variables, variables) {
+			c.Events = append(c.Events, mm.Event{
+				Ts:       c.Ts,
+				Instance: m.name,
+				Type:     mm.EventVariable,
+				Text:     fmt.Sprintf("%s changed from '%s' to '%s'", varName, m.variables[varName], variables[varName]),
+			})
+		}
+	}
+	m.variables = variables
+	return nil
+}
+
+// changedVariables returns the names of the variables added, removed or
+// changed from old to cur, sorted.
+func changedVariables(old, cur map[string]string) []string {
+	changed := []string{}
+	for varName, value := range cur {
+		if oldValue, ok := old[varName]; !ok || oldValue != value {
+			changed = append(changed, varName)
+		}
+	}
+	for varName := range old {
+		if _, ok := cur[varName]; !ok {
+			changed = append(changed, varName)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,185 @@
+package mysqlCollector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+var errNotLocal = errors.New("mysqld isn't on this host, so its process and filesystems can't be read; connect with a local DSN")
+
+// Time to full is projected from the trend of used bytes over trendWindow
+// seconds, rolled up from storage in points of trendResolution seconds.
+const (
+	trendWindow     = 6 * 3600
+	trendResolution = 300
+)
+
+type usageSample struct {
+	ts   int64
+	used float64
+}
+
+// --------------------------------------------------------------------------
+// Filesystem usage
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) GetFilesystemMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetFilesystemMetrics:call")
+	defer log.Debug("GetFilesystemMetrics:return")
+
+	// The dirs are paths on the MySQL server, so statfs is only right if
+	// the server is on this host.
+	local, err := m.isLocal()
+	if err != nil {
+		// E.g. DNS is down; try again next interval.
+		return err
+	}
+	if !local {
+		return errNotLocal
+	}
+
+	dirs := m.mysqlDirs(ctx)
+	if len(dirs) == 0 {
+		return fmt.Errorf("Cannot get @@datadir")
+	}
+	if m.usage == nil {
+		m.usage = make(map[string][]usageSample)
+	}
+	stored := m.storedUsage(c.Ts)
+
+	// Collect the other dirs if one fails, e.g. tmpdir isn't readable.
+	errs := []string{}
+	for _, role := range []string{"data", "log", "tmp", "binlog"} {
+		dir, ok := dirs[role]
+		if !ok {
+			continue
+		}
+		u, err := statfs(dir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s dir %s: %s", role, dir, err))
+			continue
+		}
+		used := u.usedBytes
+		labels := map[string]string{"dir": role, "path": dir}
+		c.Metrics = append(c.Metrics,
+			mm.Metric{"mysql/fs/total_bytes", "gauge", u.totalBytes, "", labels},
+			mm.Metric{"mysql/fs/free_bytes", "gauge", u.freeBytes, "", labels},
+			mm.Metric{"mysql/fs/used_bytes", "gauge", used, "", labels},
+			mm.Metric{"mysql/fs/free_inodes", "gauge", u.freeFiles, "", labels},
+			mm.Metric{"mysql/fs/used_inodes", "gauge", u.totalFiles - u.freeFiles, "", labels},
+		)
+
+		// Project time to full from the trend of used bytes: the stored
+		// trend, which outlasts restarts, or if storage can't be queried,
+		// the samples since the collector started.  Only the free bytes
+		// available to mysqld are left to fill.
+		samples := append(m.usage[role], usageSample{c.Ts, used})
+		for len(samples) > 0 && samples[0].ts < c.Ts-trendWindow {
+			samples = samples[1:]
+		}
+		m.usage[role] = samples
+		if stored != nil {
+			samples = append(stored[role+"\x00"+dir], usageSample{c.Ts, used})
+		}
+		if slope := usageSlope(samples); slope > 0 {
+			c.Metrics = append(c.Metrics, mm.Metric{"mysql/fs/time_to_full_seconds", "gauge", u.freeBytes / slope, "", labels})
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Cannot stat filesystems: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// mysqlDirs returns the data, InnoDB log, tmp and binlog directories keyed
+// on role.  Relative paths are relative to the datadir.
+func (m *MySQLCollector) mysqlDirs(ctx context.Context) map[string]string {
+	dirs := map[string]string{}
+	datadir := m.conn.GetGlobalVarStringContext(ctx, "datadir")
+	if datadir == "" {
+		return dirs
+	}
+	dirs["data"] = datadir
+
+	abs := func(dir string) string {
+		if filepath.IsAbs(dir) {
+			return dir
+		}
+		return filepath.Join(datadir, dir)
+	}
+
+	if dir := m.conn.GetGlobalVarStringContext(ctx, "innodb_log_group_home_dir"); dir != "" {
+		dirs["log"] = abs(dir)
+	}
+	if dir := m.conn.GetGlobalVarStringContext(ctx, "tmpdir"); dir != "" {
+		// tmpdir can be a list of paths, MySQL uses them round-robin.
+		dirs["tmp"] = abs(strings.Split(dir, ":")[0])
+	}
+	if m.conn.GetGlobalVarNumberContext(ctx, "log_bin") > 0 {
+		// @@log_bin_basename is 5.6.2+; older versions put binlogs in the datadir.
+		if base := m.conn.GetGlobalVarStringContext(ctx, "log_bin_basename"); base != "" {
+			dirs["binlog"] = filepath.Dir(abs(base))
+		} else {
+			dirs["binlog"] = datadir
+		}
+	}
+	return dirs
+}
+
+// storedUsage returns the used bytes stored over the trend window before ts,
+// keyed on dir role and path, or nil if storage can't be queried.
+func (m *MySQLCollector) storedUsage(ts int64) map[string][]usageSample {
+	if m.reader == nil {
+		return nil
+	}
+	q := mm.Query{
+		Name:       "mysql/fs/used_bytes",
+		Instance:   m.name,
+		From:       time.Unix(ts-trendWindow, 0).UTC(),
+		To:         time.Unix(ts, 0).UTC(),
+		Resolution: trendResolution,
+	}
+	series, err := m.reader.Read(q)
+	if err != nil {
+		log.Debug("Cannot read the filesystem usage trend: ", err)
+		return nil
+	}
+	usage := map[string][]usageSample{}
+	for _, s := range series {
+		key := s.Labels["dir"] + "\x00" + s.Labels["path"]
+		for _, p := range s.Points {
+			usage[key] = append(usage[key], usageSample{p.Ts.Unix(), p.Avg})
+		}
+	}
+	return usage
+}
+
+// usageSlope returns the least-squares slope of used bytes per second, or 0
+// if there aren't enough samples.
+func usageSlope(samples []usageSample) float64 {
+	n := float64(len(samples))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := float64(s.ts - samples[0].ts)
+		sumX += x
+		sumY += s.used
+		sumXY += x * s.used
+		sumXX += x * x
+	}
+	d := n*sumXX - sumX*sumX
+	if d == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / d
+}
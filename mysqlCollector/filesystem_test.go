@@ -0,0 +1,70 @@
+package mysqlCollector
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+// A usageReader returns the series for any query and records the queries.
+type usageReader struct {
+	series  []*mm.Series
+	queries []mm.Query
+}
+
+func (r *usageReader) Read(q mm.Query) ([]*mm.Series, error) {
+	r.queries = append(r.queries, q)
+	return r.series, nil
+}
+
+func (r *usageReader) Names(instance string) ([]string, error) { return nil, nil }
+func (r *usageReader) Events(q mm.Query) ([]mm.Event, error)   { return nil, nil }
+
+func TestStoredUsage(t *testing.T) {
+	m := NewMysqlCollector("db1", DefaultConfig())
+	if m.storedUsage(1600000000) != nil {
+		t.Error("got stored usage without a Reader")
+	}
+
+	// 1 MB more every 300s point: 3333.33 bytes/s.
+	r := &usageReader{
+		series: []*mm.Series{
+			{
+				Instance: "db1",
+				Name:     "mysql/fs/used_bytes",
+				Labels:   map[string]string{"dir": "data", "path": "/var/lib/mysql"},
+				Points: []*mm.Point{
+					{Ts: time.Unix(1599999000, 0), Cnt: 5, Avg: 1e6},
+					{Ts: time.Unix(1599999300, 0), Cnt: 5, Avg: 2e6},
+					{Ts: time.Unix(1599999600, 0), Cnt: 5, Avg: 3e6},
+				},
+			},
+		},
+	}
+	m.SetReader(r)
+	usage := m.storedUsage(1600000000)
+
+	q := mm.Query{
+		Name:       "mysql/fs/used_bytes",
+		Instance:   "db1",
+		From:       time.Unix(1600000000-trendWindow, 0).UTC(),
+		To:         time.Unix(1600000000, 0).UTC(),
+		Resolution: trendResolution,
+	}
+	if len(r.queries) != 1 || !reflect.DeepEqual(r.queries[0], q) {
+		t.Errorf("got queries %+v, expected %+v", r.queries, q)
+	}
+	want := map[string][]usageSample{
+		"data\x00/var/lib/mysql": {{1599999000, 1e6}, {1599999300, 2e6}, {1599999600, 3e6}},
+	}
+	if !reflect.DeepEqual(usage, want) {
+		t.Fatalf("got %+v, expected %+v", usage, want)
+	}
+	samples := append(usage["data\x00/var/lib/mysql"], usageSample{1599999900, 4e6})
+	if slope := usageSlope(samples); math.Abs(slope-1e6/300) > 1e-6 {
+		t.Errorf("got slope %f, expected %f", slope, 1e6/300)
+	}
+}
@@ -1,7 +1,9 @@
 package mysqlCollector
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -11,6 +13,7 @@ import (
 
 	"../mm"
 	"../mysql"
+	"../pct"
 	log "github.com/Sirupsen/logrus"
 )
 
@@ -19,33 +22,150 @@ var (
 	networkError = errors.New("Network error")
 )
 
+func init() {
+	mm.RegisterCollector("mysql", func(name string, data []byte) (mm.Collector, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if config.DSN == "" {
+			return nil, errors.New("DSN is not set")
+		}
+		if config.QueryTimeout < 0 {
+			return nil, fmt.Errorf("Invalid QueryTimeout: %d", config.QueryTimeout)
+		}
+		for i := range config.Queries {
+			if err := config.Queries[i].validate(); err != nil {
+				return nil, err
+			}
+		}
+		return NewMysqlCollector(name, config), nil
+	})
+}
+
 type MySQLCollector struct {
-	url            string
+	name           string
 	conn           mysql.Connector
 	config         *Config
+	sources        []*source
+	pid            int                      // mysqld PID
+	pidWait        time.Duration            // backoff while the PID can't be found
+	pidNext        time.Time                // when to look for the PID again
+	pidErr         string                   // why the PID can't be found, as last logged
+	local          int                      // mysqld is on this host: 1 yes, -1 no, 0 don't know yet
+	usage          map[string][]usageSample // filesystem used bytes, keyed on dir role
+	reader         mm.Reader                // stored reports, nil if storage can't be queried
+	uptime         int64                    // last Uptime, to detect restarts
+	variables      map[string]string        // last SHOW GLOBAL VARIABLES, to detect changes
 	tickChan       <-chan time.Time
 	collectionChan chan *mm.Collection
 	connectedChan  chan bool
+	ctx            context.Context // canceled by Stop to abort queries
+	cancel         context.CancelFunc
+	stopChan       chan bool
+	doneChan       chan bool
+	status         *pct.Status
+	selfLabels     map[string]string
+}
+
+// A source is one set of metrics collected on its own interval.
+type source struct {
+	name     string
+	interval int64         // seconds, 0 = disabled
+	timeout  time.Duration // 0 = none
+	next     int64         // Unix ts when source is due next
+	collect  func(context.Context, *sql.DB, *mm.Collection) error
+	noSQL    bool // doesn't query MySQL, so not counted in self/mysql/source_runs
+
+	selfLabels map[string]string // for mm.Self metrics
+}
+
+// due returns true if the source should be collected at ts.  Collections are
+// aligned to multiples of the interval so they line up with mm.Aggregator
+// intervals.
+func (s *source) due(ts int64) bool {
+	if s.interval <= 0 || ts < s.next {
+		return false
+	}
+	s.next = (ts/s.interval + 1) * s.interval
+	return true
 }
 
-func NewMysqlCollector(url string) *MySQLCollector {
+func NewMysqlCollector(name string, config *Config) *MySQLCollector {
+	conn := mysql.NewConnection(config.DSN)
+	conn.SetQueryTimeout(time.Duration(config.QueryTimeout) * time.Millisecond)
 	m := &MySQLCollector{
-		url:           url,
-		conn:          mysql.NewConnection(url),
+		name:          name,
+		conn:          conn,
 		connectedChan: make(chan bool, 1),
-		config:        &Config{Status: GlobalMySQLStatus, InnoDB: []string{"%"}},
+		config:        config,
+		status:        pct.NewStatus([]string{name, name + "-last-collection"}),
+		selfLabels:    map[string]string{"collector": name},
 	}
 	return m
 }
 
+func (m *MySQLCollector) Name() string {
+	return m.name
+}
+
+// SetReader makes the collector an mm.HistoryCollector: filesystem time to
+// full is projected from the stored trend of used bytes.
+func (m *MySQLCollector) SetReader(reader mm.Reader) {
+	m.reader = reader
+}
+
 func (m *MySQLCollector) Start(tickChan <-chan time.Time, collectionChan chan *mm.Collection) error {
 	m.tickChan = tickChan
 	m.collectionChan = collectionChan
+	m.sources = []*source{
+		{name: "status", interval: m.config.StatusInterval, collect: m.GetShowStatusMetrics},
+		{name: "InnoDB metrics", interval: m.config.InnoDBInterval, collect: m.GetInnoDBMetrics},
+		{name: "processlist", interval: m.config.ProcesslistInterval, collect: m.GetProcesslistMetrics},
+		{name: "table sizes", interval: m.config.TableSizeInterval, collect: m.GetTableSizeMetrics},
+		{name: "replication", interval: m.config.ReplicationInterval, collect: m.GetReplicationMetrics},
+		{name: "process", interval: m.config.ProcessInterval, collect: m.GetProcessMetrics, noSQL: true},
+		{name: "filesystems", interval: m.config.FilesystemInterval, collect: m.GetFilesystemMetrics, noSQL: true},
+		{name: "events", interval: m.config.EventsInterval, collect: m.GetEvents},
+	}
+	if len(m.config.InnoDB) == 0 {
+		m.sources[1].interval = 0
+	}
+	for _, q := range m.config.Queries {
+		s := &source{name: "query " + q.Name, interval: q.Interval, collect: m.getQueryMetrics(q)}
+		if q.Timeout > 0 {
+			s.timeout = time.Duration(q.Timeout) * time.Millisecond
+		}
+		m.sources = append(m.sources, s)
+	}
+	for _, s := range m.sources {
+		if s.timeout == 0 {
+			s.timeout = time.Duration(m.config.QueryTimeout) * time.Millisecond
+		}
+		s.selfLabels = map[string]string{"collector": m.name, "source": s.name}
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.stopChan = make(chan bool)
+	m.doneChan = make(chan bool)
 	go m.run()
 
 	return nil
 }
 
+func (m *MySQLCollector) Stop() error {
+	// Cancel queries in progress, else a hung server blocks Stop.
+	m.cancel()
+	close(m.stopChan)
+	<-m.doneChan
+	return nil
+}
+
+func (m *MySQLCollector) Status() map[string]string {
+	return m.status.All()
+}
+
 func (m *MySQLCollector) connect() {
 	log.Debug("connect:call")
 	defer func() {
@@ -59,12 +179,27 @@ func (m *MySQLCollector) connect() {
 
 	// Try forever to connect to MySQL...
 	for {
+		select {
+		case <-m.stopChan:
+			return
+		case <-m.ctx.Done():
+			return
+		default:
+		}
+
 		log.Debug("connect:try")
-		if err := m.conn.Connect(1); err != nil {
+		m.status.Update(m.name, "Connecting to "+mysql.HideDSNPassword(m.config.DSN))
+		err := m.conn.ConnectContext(m.ctx, 1)
+		mm.Self.Inc("self/mysql/connects", m.selfLabels)
+		mm.Self.Set("self/mysql/backoff_seconds", m.selfLabels, m.conn.BackoffWait().Seconds())
+		if err != nil {
 			log.Warn(err)
+			mm.Self.Inc("self/mysql/connect_errors", m.selfLabels)
+			m.status.Update(m.name, "Not connected: "+err.Error())
 			continue
 		}
 		log.Info("Connected")
+		m.status.Update(m.name, "Connected")
 
 		m.setGlobalVars()
 
@@ -107,14 +242,15 @@ func (m *MySQLCollector) run() {
 			log.Error("MySQL monitor crashed: ", err)
 		}
 		m.conn.Close()
+		m.status.Update(m.name, "Stopped")
 		log.Debug("run:return")
+		close(m.doneChan)
 	}()
 
 	connected := false
 	go m.connect()
 
 	for {
-
 		select {
 		case now := <-m.tickChan:
 			log.Debug("run:collect:start")
@@ -122,73 +258,113 @@ func (m *MySQLCollector) run() {
 				log.Debug("run:collect:disconnected")
 				continue
 			}
-
-			c := &mm.Collection{
-				Ts:      now.UTC().Unix(),
-				Metrics: []mm.Metric{},
+			connected = m.collect(now.UTC().Unix())
+			if connected {
+				m.status.Update(m.name+"-last-collection", now.UTC().Format(time.RFC3339))
+			} else {
+				// Reconnect; sources are collected again when they're next
+				// due after connect() says we're connected.
+				m.status.Update(m.name, "Lost connection")
+				go m.connect()
 			}
+			log.Debug("run:collect:stop")
+		case connected = <-m.connectedChan:
+			log.Debug("run:connected:true")
+		case <-m.stopChan:
+			return
+		}
+	}
+}
 
-			// Start timing the collection.  If must take < collectLimit else
-			// it's discarded.
-			conn := m.conn.DB()
+// collect collects every source due at ts and sends a collection for each.
+// It returns false if the connection to MySQL was lost.
+func (m *MySQLCollector) collect(ts int64) bool {
+	conn := m.conn.DB()
+	for _, s := range m.sources {
+		if !s.due(ts) {
+			continue
+		}
 
-			// SHOW GLOBAL STATUS
-			if err := m.GetShowStatusMetrics(conn, c); err != nil {
-				if m.collectError(err) == networkError {
-					connected = false
-					continue
-				}
+		c := &mm.Collection{
+			Instance: m.name,
+			Ts:       ts,
+			Interval: s.interval,
+			Metrics:  []mm.Metric{},
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if s.timeout > 0 {
+			ctx, cancel = context.WithTimeout(m.ctx, s.timeout)
+		} else {
+			ctx, cancel = context.WithCancel(m.ctx)
+		}
+		t0 := time.Now()
+		err := s.collect(ctx, conn, c)
+		cancel()
+		mm.Self.Set("self/collect/seconds", s.selfLabels, time.Since(t0).Seconds())
+		if !s.noSQL {
+			// Once per source run, however many statements it runs:
+			// e.g. events runs SHOW STATUS and SHOW VARIABLES.
+			mm.Self.Inc("self/mysql/source_runs", s.selfLabels)
+		}
+		if m.ctx.Err() != nil {
+			// Stopping; the collection is incomplete.
+			return true
+		}
+		if err != nil && (ctx.Err() == context.DeadlineExceeded || mysql.MySQLErrorCode(err) == mysql.ER_QUERY_TIMEOUT) {
+			log.Warn(fmt.Sprintf("Canceled MySQL %s queries after %s: %s", s.name, s.timeout, err))
+			if !s.noSQL {
+				mm.Self.Inc("self/mysql/query_timeouts", s.selfLabels)
 			}
-			//fmt.Printf("global : %+v\n",c.Metrics)
-
-			// SELECT NAME, ... FROM INFORMATION_SCHEMA.INNODB_METRICS
-			if len(m.config.InnoDB) > 0 {
-				if err := m.GetInnoDBMetrics(conn, c); err != nil {
-					switch m.collectError(err) {
-					case accessDenied:
-						m.config.InnoDB = []string{}
-					case networkError:
-						connected = false
-						continue
-					}
-				}
+		} else if err != nil {
+			if !s.noSQL {
+				mm.Self.Inc("self/mysql/query_errors", s.selfLabels)
 			}
-
-			// It is possible that collecting metrics will stall for many
-			// seconds for some reason so even though we issued captures 1 sec in
-			// between, we actually got 5 seconds between results and as such we
-			// might be showing huge spike.
-			// To avoid that, if the time to collect metrics is >= collectLimit
-			// then warn and discard the metrics.
-
-			// Send the metrics to an mm.Aggregator.
-			if len(c.Metrics) > 0 {
-				select {
-				case m.collectionChan <- c:
-				case <-time.After(500 * time.Millisecond):
-					// lost collection
-					log.Debug("Lost MySQL metrics; timeout spooling after 500ms")
-				}
-			} else {
-				log.Debug("run:no metrics") // shouldn't happen
+			// Other errors are retried when the source is next due.
+			switch m.collectError(s.name, err) {
+			case accessDenied, errNotLocal:
+				// Don't try again, it won't work until privileges change
+				// or, for errNotLocal, mysqld isn't on this host.
+				s.interval = 0
+			case networkError:
+				return false
 			}
+		}
 
-			log.Debug("run:collect:stop")
-		case connected = <-m.connectedChan:
-			log.Debug("run:connected:true")
+		// It is possible that collecting metrics will stall for many
+		// seconds for some reason so even though we issued captures 1 sec in
+		// between, we actually got 5 seconds between results and as such we
+		// might be showing huge spike.
+		// To avoid that, if the time to collect metrics is >= collectLimit
+		// then warn and discard the metrics.
+
+		// Send the metrics to an mm.Aggregator.
+		if len(c.Metrics) > 0 || len(c.Events) > 0 {
+			select {
+			case m.collectionChan <- c:
+			case <-time.After(500 * time.Millisecond):
+				// lost collection
+				log.Debug("Lost MySQL " + s.name + " metrics; timeout spooling after 500ms")
+				mm.Self.Inc("self/collect/lost", m.selfLabels)
+			}
+		} else {
+			log.Debug("run:no " + s.name + " metrics")
 		}
 	}
+	return true
 }
 
 // --------------------------------------------------------------------------
 // SHOW STATUS
 // --------------------------------------------------------------------------
 
-func (m *MySQLCollector) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) error {
+func (m *MySQLCollector) GetShowStatusMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
 	log.Debug("GetShowStatusMetrics:call")
 	defer log.Debug("GetShowStatusMetrics:return")
 
-	rows, err := conn.Query("SHOW /*!50002 GLOBAL */ STATUS")
+	// SHOW can't have a MAX_EXECUTION_TIME hint, so only ctx limits it.
+	rows, err := conn.QueryContext(ctx, "SHOW /*!50002 GLOBAL */ STATUS")
 	if err != nil {
 		return err
 	}
@@ -219,7 +395,7 @@ func (m *MySQLCollector) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) er
 			continue
 		}
 
-		c.Metrics = append(c.Metrics, mm.Metric{"mysql/" + statName, metricType, metricValue, ""})
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/" + statName, metricType, metricValue, "", nil})
 	}
 	err = rows.Err()
 	if err != nil {
@@ -234,11 +410,12 @@ func (m *MySQLCollector) GetShowStatusMetrics(conn *sql.DB, c *mm.Collection) er
 // https://blogs.oracle.com/mysqlinnodb/entry/get_started_with_innodb_metrics
 // --------------------------------------------------------------------------
 
-func (m *MySQLCollector) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error {
+func (m *MySQLCollector) GetInnoDBMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
 	log.Debug("GetInnoDBMetrics:call")
 	defer log.Debug("GetInnoDBMetrics:return")
 
-	rows, err := conn.Query("SELECT NAME, SUBSYSTEM, COUNT, TYPE FROM INFORMATION_SCHEMA.INNODB_METRICS WHERE STATUS='enabled'")
+	rows, err := conn.QueryContext(ctx, mysql.MaxExecutionTime(ctx,
+		"SELECT NAME, SUBSYSTEM, COUNT, TYPE FROM INFORMATION_SCHEMA.INNODB_METRICS WHERE STATUS='enabled'"))
 	if err != nil {
 		return err
 	}
@@ -253,7 +430,8 @@ func (m *MySQLCollector) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error
 			return err
 		}
 
-		metricName := "mysql/innodb/" + strings.ToLower(statSubsystem) + "/" + strings.ToLower(statName)
+		metricName := "mysql/innodb/" + strings.ToLower(statName)
+		labels := map[string]string{"subsystem": strings.ToLower(statSubsystem)}
 		metricValue, err := strconv.ParseFloat(statCount, 64)
 		if err != nil {
 			log.Warn(fmt.Sprintf("Cannot convert '%s' value '%s' to float: %s", metricName, metricValue, err))
@@ -265,7 +443,94 @@ func (m *MySQLCollector) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error
 		} else {
 			metricType = "counter"
 		}
-		c.Metrics = append(c.Metrics, mm.Metric{metricName, metricType, metricValue, ""})
+		c.Metrics = append(c.Metrics, mm.Metric{metricName, metricType, metricValue, "", labels})
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// Processlist
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) GetProcesslistMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetProcesslistMetrics:call")
+	defer log.Debug("GetProcesslistMetrics:return")
+
+	rows, err := conn.QueryContext(ctx, mysql.MaxExecutionTime(ctx,
+		"SELECT COMMAND, IFNULL(STATE, ''), COUNT(*) FROM INFORMATION_SCHEMA.PROCESSLIST GROUP BY 1, 2"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	commands := map[string]float64{}
+	states := map[string]float64{}
+	var total float64
+	for rows.Next() {
+		var command string
+		var state string
+		var count float64
+		if err = rows.Scan(&command, &state, &count); err != nil {
+			return err
+		}
+		commands[metricName(command)] += count
+		if state != "" {
+			states[metricName(state)] += count
+		}
+		total += count
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	c.Metrics = append(c.Metrics, mm.Metric{"mysql/processlist/total", "gauge", total, "", nil})
+	for command, count := range commands {
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/processlist/command", "gauge", count, "", map[string]string{"command": command}})
+	}
+	for state, count := range states {
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/processlist/state", "gauge", count, "", map[string]string{"state": state}})
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// Table sizes
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) GetTableSizeMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetTableSizeMetrics:call")
+	defer log.Debug("GetTableSizeMetrics:return")
+
+	rows, err := conn.QueryContext(ctx, mysql.MaxExecutionTime(ctx,
+		"SELECT TABLE_SCHEMA, TABLE_NAME, IFNULL(TABLE_ROWS, 0), IFNULL(DATA_LENGTH, 0), IFNULL(INDEX_LENGTH, 0), IFNULL(DATA_FREE, 0)"+
+			" FROM INFORMATION_SCHEMA.TABLES"+
+			" WHERE TABLE_TYPE = 'BASE TABLE'"+
+			" AND TABLE_SCHEMA NOT IN ('mysql', 'information_schema', 'performance_schema', 'sys')"))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema string
+		var table string
+		var tableRows float64
+		var dataLength float64
+		var indexLength float64
+		var dataFree float64
+		if err = rows.Scan(&schema, &table, &tableRows, &dataLength, &indexLength, &dataFree); err != nil {
+			return err
+		}
+		labels := map[string]string{"schema": schema, "table": table}
+		c.Metrics = append(c.Metrics,
+			mm.Metric{"mysql/table/rows", "gauge", tableRows, "", labels},
+			mm.Metric{"mysql/table/data_length", "gauge", dataLength, "", labels},
+			mm.Metric{"mysql/table/index_length", "gauge", indexLength, "", labels},
+			mm.Metric{"mysql/table/data_free", "gauge", dataFree, "", labels},
+		)
 	}
 	err = rows.Err()
 	if err != nil {
@@ -274,10 +539,104 @@ func (m *MySQLCollector) GetInnoDBMetrics(conn *sql.DB, c *mm.Collection) error
 	return nil
 }
 
-func (m *MySQLCollector) collectError(err error) error {
-	switch {
-	case mysql.MySQLErrorCode(err) == mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR:
-		log.Error(fmt.Sprintf("Cannot collect InnoDB stats: %s", err))
+// --------------------------------------------------------------------------
+// SHOW SLAVE STATUS
+// --------------------------------------------------------------------------
+
+// Numeric SHOW SLAVE STATUS columns to collect, lowercase.
+var replicationColumns = map[string]string{
+	"seconds_behind_master": "gauge",
+	"read_master_log_pos":   "gauge",
+	"exec_master_log_pos":   "gauge",
+	"relay_log_space":       "gauge",
+	"last_io_errno":         "gauge",
+	"last_sql_errno":        "gauge",
+}
+
+func (m *MySQLCollector) GetReplicationMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetReplicationMetrics:call")
+	defer log.Debug("GetReplicationMetrics:return")
+
+	rows, err := conn.QueryContext(ctx, "SHOW SLAVE STATUS")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// The columns vary by MySQL version, so scan them all by name.
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	// No rows means this server isn't a slave.
+	if !rows.Next() {
+		return rows.Err()
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return err
+	}
+	for i, column := range columns {
+		column = strings.ToLower(column)
+		value := string(values[i])
+		switch column {
+		case "slave_io_running", "slave_sql_running":
+			running := 0.0
+			if value == "Yes" {
+				running = 1.0
+			}
+			c.Metrics = append(c.Metrics, mm.Metric{"mysql/replication/" + column, "gauge", running, "", nil})
+			continue
+		}
+		metricType, ok := replicationColumns[column]
+		if !ok || value == "" {
+			// Not collecting this column, or it's NULL, e.g. Seconds_Behind_Master
+			// when the SQL thread isn't running.
+			continue
+		}
+		metricValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Warn(fmt.Sprintf("Cannot convert '%s' value '%s' to float: %s", column, value, err))
+			continue
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/replication/" + column, metricType, metricValue, "", nil})
+	}
+	return rows.Err()
+}
+
+// metricName makes s usable as part of a metric name, e.g. "Sending data"
+// becomes "sending_data".
+func metricName(s string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(s)), " ", "_", -1)
+}
+
+// isLocal returns true if mysqld is on this host, so its /proc and
+// filesystems can be read.  The answer is kept, since the DSN doesn't
+// change, but an error, e.g. DNS is down, isn't, so it's retried.
+func (m *MySQLCollector) isLocal() (bool, error) {
+	if m.local == 0 {
+		local, err := mysql.LocalDSN(m.config.DSN)
+		if err != nil {
+			return false, fmt.Errorf("Cannot tell if mysqld is on this host: %s", err)
+		}
+		if local {
+			m.local = 1
+		} else {
+			m.local = -1
+		}
+	}
+	return m.local > 0, nil
+}
+
+func (m *MySQLCollector) collectError(source string, err error) error {
+	switch mysql.MySQLErrorCode(err) {
+	case mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, mysql.ER_USER_DENIED:
+		log.Error(fmt.Sprintf("Cannot collect %s: %s", source, err))
 		return accessDenied
 	}
 	switch err.(type) {
@@ -0,0 +1,273 @@
+package mysqlCollector
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+var errNoPid = errors.New("Cannot find mysqld PID; set Pid in the config or check @@pid_file and @@socket")
+
+// Clock ticks per second used by /proc/<pid>/stat (USER_HZ).  It's 100 on
+// every architecture we run on.
+const userHZ = 100
+
+// The longest wait between looks for the mysqld PID while it can't be found.
+const maxPidWait = 5 * time.Minute
+
+// --------------------------------------------------------------------------
+// mysqld process
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) GetProcessMetrics(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+	log.Debug("GetProcessMetrics:call")
+	defer log.Debug("GetProcessMetrics:return")
+
+	// The PID changes if mysqld restarts, so find it again if it's gone.
+	if m.pid == 0 || !m.procExists(m.pid) {
+		m.pid = 0
+		if time.Now().Before(m.pidNext) {
+			return nil // waiting to look again
+		}
+		pid, err := m.findPid(ctx)
+		if err == errNotLocal {
+			return err
+		}
+		if err != nil {
+			// Looking runs queries and reads the fds of every process, so
+			// back off while the PID can't be found, e.g. in a container,
+			// and only warn when the reason changes.
+			m.pidWait *= 2
+			if m.pidWait == 0 {
+				m.pidWait = time.Second
+			} else if m.pidWait > maxPidWait {
+				m.pidWait = maxPidWait
+			}
+			m.pidNext = time.Now().Add(m.pidWait)
+			if err.Error() != m.pidErr {
+				log.Warn(fmt.Sprintf("%s; looking again in %s and backing off up to %s", err, m.pidWait, maxPidWait))
+				m.pidErr = err.Error()
+			} else {
+				log.Debug(err)
+			}
+			return nil
+		}
+		m.pid, m.pidWait, m.pidErr = pid, 0, ""
+		log.Info(fmt.Sprintf("mysqld PID is %d", m.pid))
+	}
+	procDir := filepath.Join(m.config.ProcDir, strconv.Itoa(m.pid))
+
+	if err := m.getProcStatus(procDir, c); err != nil {
+		return err
+	}
+	if err := m.getProcStat(procDir, c); err != nil {
+		return err
+	}
+
+	// /proc/<pid>/fd and /proc/<pid>/io are only readable by the process
+	// owner and root, so don't fail the other metrics if we can't read them.
+	if fds, err := ioutil.ReadDir(filepath.Join(procDir, "fd")); err == nil {
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/process/open_files", "gauge", float64(len(fds)), "", nil})
+	} else {
+		log.Debug(err)
+	}
+	if err := m.getProcIO(procDir, c); err != nil {
+		log.Debug(err)
+	}
+
+	return nil
+}
+
+// findPid returns the mysqld PID from the config, @@pid_file or the owner of
+// @@socket, in that order.
+func (m *MySQLCollector) findPid(ctx context.Context) (int, error) {
+	if m.config.Pid > 0 {
+		if !m.procExists(m.config.Pid) {
+			return 0, fmt.Errorf("mysqld PID %d from the config doesn't exist", m.config.Pid)
+		}
+		return m.config.Pid, nil
+	}
+
+	// @@pid_file and @@socket are paths on the MySQL server, so they only
+	// find its PID if the server is on this host.
+	local, err := m.isLocal()
+	if err != nil {
+		return 0, err
+	}
+	if !local {
+		return 0, errNotLocal
+	}
+
+	if pidFile := m.conn.GetGlobalVarStringContext(ctx, "pid_file"); pidFile != "" {
+		if data, err := ioutil.ReadFile(pidFile); err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && m.procExists(pid) {
+				return pid, nil
+			}
+		} else {
+			log.Debug(err)
+		}
+	}
+
+	if socket := m.conn.GetGlobalVarStringContext(ctx, "socket"); socket != "" {
+		if pid := m.socketOwner(socket); pid > 0 {
+			return pid, nil
+		}
+	}
+
+	return 0, errNoPid
+}
+
+func (m *MySQLCollector) procExists(pid int) bool {
+	_, err := os.Stat(filepath.Join(m.config.ProcDir, strconv.Itoa(pid)))
+	return err == nil
+}
+
+// socketOwner returns the PID of the process listening on the Unix socket,
+// or 0 if not found.
+func (m *MySQLCollector) socketOwner(socket string) int {
+	// Find the socket's inode, e.g.:
+	// Num       RefCount Protocol Flags    Type St Inode Path
+	// 0000...:  00000002 00000000 00010000 0001 01 16329 /var/run/mysqld/mysqld.sock
+	f, err := os.Open(filepath.Join(m.config.ProcDir, "net", "unix"))
+	if err != nil {
+		log.Debug(err)
+		return 0
+	}
+	defer f.Close()
+	inode := ""
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 8 && fields[7] == socket {
+			inode = fields[6]
+			break
+		}
+	}
+	if inode == "" {
+		return 0
+	}
+
+	// Find the process with an open fd to the inode.
+	link := "socket:[" + inode + "]"
+	fds, _ := filepath.Glob(filepath.Join(m.config.ProcDir, "[0-9]*", "fd", "*"))
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil && target == link {
+			pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(fd))))
+			return pid
+		}
+	}
+	return 0
+}
+
+// Fields from /proc/<pid>/status to collect.
+var procStatusFields = map[string]struct {
+	name       string
+	metricType string
+}{
+	"VmRSS":                      {"rss_bytes", "gauge"},
+	"VmSize":                     {"virtual_bytes", "gauge"},
+	"VmSwap":                     {"swap_bytes", "gauge"},
+	"Threads":                    {"threads", "gauge"},
+	"voluntary_ctxt_switches":    {"voluntary_ctxt_switches", "counter"},
+	"nonvoluntary_ctxt_switches": {"nonvoluntary_ctxt_switches", "counter"},
+}
+
+func (m *MySQLCollector) getProcStatus(procDir string, c *mm.Collection) error {
+	f, err := os.Open(filepath.Join(procDir, "status"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// VmRSS:	  123456 kB
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		field, ok := procStatusFields[strings.TrimSuffix(fields[0], ":")]
+		if !ok {
+			continue
+		}
+		val, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			val *= 1024
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/process/" + field.name, field.metricType, val, "", nil})
+	}
+	return s.Err()
+}
+
+// Fields of /proc/<pid>/stat to collect, indexed from 0 at the field after
+// the command name.
+var procStatFields = []struct {
+	field int
+	name  string
+}{
+	{11, "cpu_user_seconds"},
+	{12, "cpu_system_seconds"},
+}
+
+func (m *MySQLCollector) getProcStat(procDir string, c *mm.Collection) error {
+	data, err := ioutil.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return err
+	}
+	// The command name (2nd field) is in parens and can contain spaces,
+	// so split after it.  utime and stime are the 14th and 15th fields.
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		return fmt.Errorf("Cannot parse %s/stat: %d fields", procDir, len(fields))
+	}
+	for _, f := range procStatFields {
+		ticks, err := strconv.ParseFloat(fields[f.field], 64)
+		if err != nil {
+			return err
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/process/" + f.name, "counter", ticks / userHZ, "", nil})
+	}
+	return nil
+}
+
+func (m *MySQLCollector) getProcIO(procDir string, c *mm.Collection) error {
+	f, err := os.Open(filepath.Join(procDir, "io"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// read_bytes: 4096
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(fields[0], ":")
+		switch name {
+		case "rchar", "wchar", "read_bytes", "write_bytes", "syscr", "syscw":
+		default:
+			continue
+		}
+		val, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"mysql/process/io_" + name, "counter", val, "", nil})
+	}
+	return s.Err()
+}
@@ -0,0 +1,72 @@
+package mysqlCollector
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+func TestProcessPidBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mysqlCollector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.DSN = "root@tcp(127.0.0.1:3306)/"
+	config.ProcDir = dir
+	config.Pid = 4242
+	m := NewMysqlCollector("mysql", config)
+
+	// The PID doesn't exist yet: no metrics and no error, but a backoff.
+	c := &mm.Collection{}
+	if err := m.GetProcessMetrics(context.Background(), nil, c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Metrics) != 0 || m.pidWait != time.Second || m.pidErr == "" {
+		t.Errorf("got %d metrics, pidWait %s, pidErr %q; expected 0, 1s and an error", len(c.Metrics), m.pidWait, m.pidErr)
+	}
+
+	// Not looked for again until the wait is over.
+	procDir := filepath.Join(dir, "4242")
+	if err := os.Mkdir(procDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"status": "Name:\tmysqld\nVmRSS:\t     100 kB\nThreads:\t37\n",
+		"stat":   "4242 (my sqld) S 1 4242 4242 0 -1 4194560 1 0 0 0 250 150 0 0 20 0 37 0 1 1 1\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(procDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.GetProcessMetrics(context.Background(), nil, c); err != nil || m.pid != 0 {
+		t.Errorf("got error %v, PID %d; expected to wait before looking again", err, m.pid)
+	}
+
+	// Found: the backoff is reset and the metrics are in order.
+	m.pidNext = time.Time{}
+	if err := m.GetProcessMetrics(context.Background(), nil, c); err != nil {
+		t.Fatal(err)
+	}
+	if m.pid != 4242 || m.pidWait != 0 || m.pidErr != "" {
+		t.Errorf("got PID %d, pidWait %s, pidErr %q; expected 4242 and no backoff", m.pid, m.pidWait, m.pidErr)
+	}
+	want := []mm.Metric{
+		{"mysql/process/rss_bytes", "gauge", 100 * 1024, "", nil},
+		{"mysql/process/threads", "gauge", 37, "", nil},
+		{"mysql/process/cpu_user_seconds", "counter", 2.5, "", nil},
+		{"mysql/process/cpu_system_seconds", "counter", 1.5, "", nil},
+	}
+	if !reflect.DeepEqual(c.Metrics, want) {
+		t.Errorf("got %+v\nwant %+v", c.Metrics, want)
+	}
+}
@@ -0,0 +1,131 @@
+package mysqlCollector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"../mm"
+	"../mysql"
+	log "github.com/Sirupsen/logrus"
+)
+
+// A Query is an arbitrary SQL query whose rows become metrics.  Each row
+// yields one metric per Metrics column named <Name>/<metric>, labeled with
+// the row's values for the Labels columns.
+type Query struct {
+	Name     string        // metric name prefix, e.g. app/jobs
+	SQL      string        // SELECT queue, COUNT(*) AS depth FROM jobs GROUP BY queue
+	Interval int64         // seconds, default 60
+	Timeout  int64         // milliseconds, default the collector's QueryTimeout
+	Labels   []string      // columns that identify the row, e.g. queue
+	Metrics  []QueryMetric // columns that are values, e.g. depth
+}
+
+type QueryMetric struct {
+	Column string // column name, case-insensitive
+	Name   string // metric name, defaults to lowercase column name
+	Type   string // gauge or counter
+}
+
+const DefaultQueryInterval = 60
+
+func (q *Query) validate() error {
+	if q.Name == "" || q.SQL == "" {
+		return fmt.Errorf("Query Name and SQL are required")
+	}
+	if len(q.Metrics) == 0 {
+		return fmt.Errorf("Query %s: no Metrics", q.Name)
+	}
+	if q.Interval <= 0 {
+		q.Interval = DefaultQueryInterval
+	}
+	if q.Timeout < 0 {
+		return fmt.Errorf("Query %s: invalid Timeout: %d", q.Name, q.Timeout)
+	}
+	for i := range q.Metrics {
+		m := &q.Metrics[i]
+		if m.Column == "" {
+			return fmt.Errorf("Query %s: metric %d has no Column", q.Name, i)
+		}
+		if m.Name == "" {
+			m.Name = strings.ToLower(m.Column)
+		}
+		if !mm.MetricTypes[m.Type] {
+			return fmt.Errorf("Query %s: metric %s: invalid type: %s", q.Name, m.Name, m.Type)
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// Custom queries
+// --------------------------------------------------------------------------
+
+func (m *MySQLCollector) getQueryMetrics(q Query) func(context.Context, *sql.DB, *mm.Collection) error {
+	return func(ctx context.Context, conn *sql.DB, c *mm.Collection) error {
+		log.Debug("getQueryMetrics:call:" + q.Name)
+		defer log.Debug("getQueryMetrics:return:" + q.Name)
+
+		rows, err := conn.QueryContext(ctx, mysql.MaxExecutionTime(ctx, q.SQL))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		index := make(map[string]int, len(columns))
+		for i, column := range columns {
+			index[strings.ToLower(column)] = i
+		}
+		for _, column := range q.Labels {
+			if _, ok := index[strings.ToLower(column)]; !ok {
+				return fmt.Errorf("Query %s: no label column %s", q.Name, column)
+			}
+		}
+		for _, metric := range q.Metrics {
+			if _, ok := index[strings.ToLower(metric.Column)]; !ok {
+				return fmt.Errorf("Query %s: no metric column %s", q.Name, metric.Column)
+			}
+		}
+
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		for rows.Next() {
+			if err = rows.Scan(dest...); err != nil {
+				return err
+			}
+
+			var labels map[string]string
+			if len(q.Labels) > 0 {
+				labels = make(map[string]string, len(q.Labels))
+				for _, column := range q.Labels {
+					labels[strings.ToLower(column)] = string(values[index[strings.ToLower(column)]])
+				}
+			}
+
+			for _, metric := range q.Metrics {
+				name := q.Name + "/" + metric.Name
+				value := values[index[strings.ToLower(metric.Column)]]
+				if value == nil {
+					continue // NULL
+				}
+				metricValue, err := strconv.ParseFloat(string(value), 64)
+				if err != nil {
+					log.Warn(fmt.Sprintf("Cannot convert '%s' value '%s' to float: %s", mm.MetricKey(name, labels), value, err))
+					continue
+				}
+				c.Metrics = append(c.Metrics, mm.Metric{name, metric.Type, metricValue, "", labels})
+			}
+		}
+		return rows.Err()
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package mysqlCollector
+
+import (
+	"syscall"
+)
+
+type fsUsage struct {
+	totalBytes float64
+	usedBytes  float64 // like df, not counting blocks reserved for root
+	freeBytes  float64 // available to unprivileged users
+	totalFiles float64
+	freeFiles  float64
+}
+
+func statfs(dir string) (fsUsage, error) {
+	var s syscall.Statfs_t
+	if err := syscall.Statfs(dir, &s); err != nil {
+		return fsUsage{}, err
+	}
+	u := fsUsage{
+		totalBytes: float64(s.Blocks) * float64(s.Bsize),
+		usedBytes:  float64(s.Blocks-s.Bfree) * float64(s.Bsize),
+		freeBytes:  float64(s.Bavail) * float64(s.Bsize),
+		totalFiles: float64(s.Files),
+		freeFiles:  float64(s.Ffree),
+	}
+	return u, nil
+}
@@ -0,0 +1,17 @@
+package mysqlCollector
+
+import (
+	"errors"
+)
+
+type fsUsage struct {
+	totalBytes float64
+	usedBytes  float64
+	freeBytes  float64
+	totalFiles float64
+	freeFiles  float64
+}
+
+func statfs(dir string) (fsUsage, error) {
+	return fsUsage{}, errors.New("Filesystem metrics are not supported on Windows")
+}
@@ -0,0 +1,31 @@
+package osCollector
+
+type Config struct {
+	ProcDir string            // usually /proc
+	VMStat  map[string]string // /proc/vmstat variables to collect
+}
+
+func DefaultConfig() *Config {
+	// Copy the variables so config changes don't affect other collectors.
+	vmstat := make(map[string]string, len(VMStat))
+	for k, v := range VMStat {
+		vmstat[k] = v
+	}
+	c := &Config{
+		ProcDir: "/proc",
+		VMStat:  vmstat,
+	}
+	return c
+}
+
+var VMStat = map[string]string{
+	"nr_dirty":     "gauge",
+	"nr_writeback": "gauge",
+	"pgpgin":       "counter",
+	"pgpgout":      "counter",
+	"pswpin":       "counter",
+	"pswpout":      "counter",
+	"pgfault":      "counter",
+	"pgmajfault":   "counter",
+	"oom_kill":     "counter",
+}
@@ -0,0 +1,355 @@
+package osCollector
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../mm"
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	mm.RegisterCollector("os", func(name string, data []byte) (mm.Collector, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		for name, metricType := range config.VMStat {
+			if !mm.MetricTypes[metricType] {
+				return nil, fmt.Errorf("Invalid VMStat %s type: %s; expected gauge or counter", name, metricType)
+			}
+		}
+		return NewOSCollector(name, config), nil
+	})
+}
+
+type OSCollector struct {
+	name           string
+	config         *Config
+	tickChan       <-chan time.Time
+	collectionChan chan *mm.Collection
+	stopChan       chan bool
+	doneChan       chan bool
+	status         *pct.Status
+	selfLabels     map[string]string
+}
+
+func NewOSCollector(name string, config *Config) *OSCollector {
+	o := &OSCollector{
+		name:       name,
+		config:     config,
+		status:     pct.NewStatus([]string{name, name + "-last-collection"}),
+		selfLabels: map[string]string{"collector": name},
+	}
+	return o
+}
+
+func (o *OSCollector) Name() string {
+	return o.name
+}
+
+func (o *OSCollector) Start(tickChan <-chan time.Time, collectionChan chan *mm.Collection) error {
+	o.tickChan = tickChan
+	o.collectionChan = collectionChan
+	o.stopChan = make(chan bool)
+	o.doneChan = make(chan bool)
+	go o.run()
+
+	return nil
+}
+
+func (o *OSCollector) Stop() error {
+	close(o.stopChan)
+	<-o.doneChan
+	return nil
+}
+
+func (o *OSCollector) Status() map[string]string {
+	return o.status.All()
+}
+
+func (o *OSCollector) run() {
+	log.Debug("run:call")
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("OS monitor crashed: ", err)
+		}
+		o.status.Update(o.name, "Stopped")
+		log.Debug("run:return")
+		close(o.doneChan)
+	}()
+
+	sources := []struct {
+		file    string
+		collect func(*bufio.Scanner, *mm.Collection) error
+	}{
+		{"stat", o.GetStatMetrics},
+		{"meminfo", o.GetMeminfoMetrics},
+		{"loadavg", o.GetLoadavgMetrics},
+		{"diskstats", o.GetDiskstatsMetrics},
+		{"net/dev", o.GetNetDevMetrics},
+		{"vmstat", o.GetVMStatMetrics},
+	}
+
+	o.status.Update(o.name, "Running")
+	for {
+		var now time.Time
+		select {
+		case now = <-o.tickChan:
+		case <-o.stopChan:
+			return
+		}
+
+		c := &mm.Collection{
+			Instance: o.name,
+			Ts:       now.UTC().Unix(),
+			Metrics:  []mm.Metric{},
+		}
+
+		t0 := time.Now()
+		var lastErr error
+		for _, s := range sources {
+			if err := o.read(s.file, c, s.collect); err != nil {
+				log.Warn(err)
+				lastErr = err
+			}
+		}
+		if lastErr != nil {
+			o.status.Update(o.name, "Error: "+lastErr.Error())
+		} else {
+			o.status.Update(o.name, "Running")
+		}
+		mm.Self.Set("self/collect/seconds", o.selfLabels, time.Since(t0).Seconds())
+		o.status.Update(o.name+"-last-collection", now.UTC().Format(time.RFC3339))
+
+		// Send the metrics to an mm.Aggregator.
+		if len(c.Metrics) > 0 {
+			select {
+			case o.collectionChan <- c:
+			case <-time.After(500 * time.Millisecond):
+				// lost collection
+				log.Debug("Lost OS metrics; timeout spooling after 500ms")
+				mm.Self.Inc("self/collect/lost", o.selfLabels)
+			}
+		} else {
+			log.Debug("run:no metrics")
+		}
+	}
+}
+
+func (o *OSCollector) read(file string, c *mm.Collection, collect func(*bufio.Scanner, *mm.Collection) error) error {
+	f, err := os.Open(filepath.Join(o.config.ProcDir, file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	if err := collect(s, c); err != nil {
+		return fmt.Errorf("Cannot parse %s: %s", f.Name(), err)
+	}
+	return s.Err()
+}
+
+// --------------------------------------------------------------------------
+// /proc/stat
+// --------------------------------------------------------------------------
+
+// Columns of the cpu line in /proc/stat, in USER_HZ.
+var cpuColumns = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal"}
+
+func (o *OSCollector) GetStatMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "cpu":
+			// Total of all CPUs; per-CPU lines (cpu0, cpu1, ...) are ignored.
+			for i, column := range cpuColumns {
+				if i+1 >= len(fields) {
+					break
+				}
+				val, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return err
+				}
+				c.Metrics = append(c.Metrics, mm.Metric{"os/cpu/" + column, "counter", val, "", nil})
+			}
+		case "ctxt", "intr", "processes":
+			val, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return err
+			}
+			c.Metrics = append(c.Metrics, mm.Metric{"os/" + fields[0], "counter", val, "", nil})
+		case "procs_running", "procs_blocked":
+			val, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return err
+			}
+			c.Metrics = append(c.Metrics, mm.Metric{"os/" + fields[0], "gauge", val, "", nil})
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// /proc/meminfo
+// --------------------------------------------------------------------------
+
+func (o *OSCollector) GetMeminfoMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	for s.Scan() {
+		// MemTotal:        8056844 kB
+		// HugePages_Total:       0
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		val, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			val *= 1024
+		}
+		// Active(anon): -> active_anon
+		name := strings.TrimSuffix(fields[0], ":")
+		name = strings.Replace(name, "(", "_", -1)
+		name = strings.Replace(name, ")", "", -1)
+		c.Metrics = append(c.Metrics, mm.Metric{"os/memory/" + strings.ToLower(name), "gauge", val, "", nil})
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// /proc/loadavg
+// --------------------------------------------------------------------------
+
+func (o *OSCollector) GetLoadavgMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	if !s.Scan() {
+		return nil
+	}
+	// 0.20 0.18 0.12 1/80 11206
+	fields := strings.Fields(s.Text())
+	if len(fields) < 3 {
+		return fmt.Errorf("expected at least 3 fields, got %d", len(fields))
+	}
+	for i, name := range []string{"1", "5", "15"} {
+		val, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return err
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"os/loadavg/" + name, "gauge", val, "", nil})
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// /proc/diskstats
+// https://www.kernel.org/doc/Documentation/iostats.txt
+// --------------------------------------------------------------------------
+
+// Columns of /proc/diskstats after major, minor and device name.
+var diskColumns = []struct {
+	name       string
+	metricType string
+}{
+	{"reads", "counter"},
+	{"reads_merged", "counter"},
+	{"read_sectors", "counter"},
+	{"read_ms", "counter"},
+	{"writes", "counter"},
+	{"writes_merged", "counter"},
+	{"write_sectors", "counter"},
+	{"write_ms", "counter"},
+	{"io_in_progress", "gauge"},
+	{"io_ms", "counter"},
+	{"io_weighted_ms", "counter"},
+}
+
+func (o *OSCollector) GetDiskstatsMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3+len(diskColumns) {
+			continue
+		}
+		device := fields[2]
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		for i, column := range diskColumns {
+			val, err := strconv.ParseFloat(fields[3+i], 64)
+			if err != nil {
+				return err
+			}
+			c.Metrics = append(c.Metrics, mm.Metric{"os/disk/" + column.name, column.metricType, val, "", map[string]string{"device": device}})
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// /proc/net/dev
+// --------------------------------------------------------------------------
+
+// Columns of /proc/net/dev after the interface name.
+var netColumns = []string{
+	"rx_bytes", "rx_packets", "rx_errs", "rx_drop", "rx_fifo", "rx_frame", "rx_compressed", "rx_multicast",
+	"tx_bytes", "tx_packets", "tx_errs", "tx_drop", "tx_fifo", "tx_colls", "tx_carrier", "tx_compressed",
+}
+
+func (o *OSCollector) GetNetDevMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	for s.Scan() {
+		// The first two lines are headers; interface lines have "<iface>:".
+		line := s.Text()
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		iface := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) < len(netColumns) {
+			continue
+		}
+		for i, column := range netColumns {
+			val, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return err
+			}
+			c.Metrics = append(c.Metrics, mm.Metric{"os/net/" + column, "counter", val, "", map[string]string{"interface": iface}})
+		}
+	}
+	return nil
+}
+
+// --------------------------------------------------------------------------
+// /proc/vmstat
+// --------------------------------------------------------------------------
+
+func (o *OSCollector) GetVMStatMetrics(s *bufio.Scanner, c *mm.Collection) error {
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		metricType, ok := o.config.VMStat[fields[0]]
+		if !ok {
+			continue // not collecting this stat
+		}
+		val, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		c.Metrics = append(c.Metrics, mm.Metric{"os/vmstat/" + fields[0], metricType, val, "", nil})
+	}
+	return nil
+}
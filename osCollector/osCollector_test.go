@@ -0,0 +1,138 @@
+package osCollector
+
+import (
+	"bufio"
+	"reflect"
+	"testing"
+
+	"../mm"
+)
+
+func TestParsers(t *testing.T) {
+	o := NewOSCollector("os", DefaultConfig())
+	o.config.ProcDir = "testdata/proc"
+
+	sda := map[string]string{"device": "sda"}
+	eth0 := map[string]string{"interface": "eth0"}
+	tests := []struct {
+		file    string
+		collect func(*bufio.Scanner, *mm.Collection) error
+		want    []mm.Metric
+	}{
+		{
+			"stat", o.GetStatMetrics,
+			[]mm.Metric{
+				{"os/cpu/user", "counter", 10, "", nil},
+				{"os/cpu/nice", "counter", 20, "", nil},
+				{"os/cpu/system", "counter", 30, "", nil},
+				{"os/cpu/idle", "counter", 40, "", nil},
+				{"os/cpu/iowait", "counter", 50, "", nil},
+				{"os/cpu/irq", "counter", 60, "", nil},
+				{"os/cpu/softirq", "counter", 70, "", nil},
+				{"os/cpu/steal", "counter", 80, "", nil},
+				{"os/intr", "counter", 12345, "", nil},
+				{"os/ctxt", "counter", 6789, "", nil},
+				{"os/processes", "counter", 111, "", nil},
+				{"os/procs_running", "gauge", 2, "", nil},
+				{"os/procs_blocked", "gauge", 1, "", nil},
+			},
+		},
+		{
+			"meminfo", o.GetMeminfoMetrics,
+			[]mm.Metric{
+				{"os/memory/memtotal", "gauge", 8056844 * 1024, "", nil},
+				{"os/memory/active_anon", "gauge", 100 * 1024, "", nil},
+				{"os/memory/hugepages_total", "gauge", 0, "", nil},
+			},
+		},
+		{
+			"loadavg", o.GetLoadavgMetrics,
+			[]mm.Metric{
+				{"os/loadavg/1", "gauge", 0.20, "", nil},
+				{"os/loadavg/5", "gauge", 0.18, "", nil},
+				{"os/loadavg/15", "gauge", 0.12, "", nil},
+			},
+		},
+		{
+			// loop and ram devices are skipped.
+			"diskstats", o.GetDiskstatsMetrics,
+			[]mm.Metric{
+				{"os/disk/reads", "counter", 100, "", sda},
+				{"os/disk/reads_merged", "counter", 1, "", sda},
+				{"os/disk/read_sectors", "counter", 200, "", sda},
+				{"os/disk/read_ms", "counter", 30, "", sda},
+				{"os/disk/writes", "counter", 50, "", sda},
+				{"os/disk/writes_merged", "counter", 2, "", sda},
+				{"os/disk/write_sectors", "counter", 400, "", sda},
+				{"os/disk/write_ms", "counter", 60, "", sda},
+				{"os/disk/io_in_progress", "gauge", 3, "", sda},
+				{"os/disk/io_ms", "counter", 70, "", sda},
+				{"os/disk/io_weighted_ms", "counter", 90, "", sda},
+			},
+		},
+		{
+			"net/dev", o.GetNetDevMetrics,
+			[]mm.Metric{
+				{"os/net/rx_bytes", "counter", 1000, "", eth0},
+				{"os/net/rx_packets", "counter", 10, "", eth0},
+				{"os/net/rx_errs", "counter", 1, "", eth0},
+				{"os/net/rx_drop", "counter", 2, "", eth0},
+				{"os/net/rx_fifo", "counter", 3, "", eth0},
+				{"os/net/rx_frame", "counter", 4, "", eth0},
+				{"os/net/rx_compressed", "counter", 5, "", eth0},
+				{"os/net/rx_multicast", "counter", 6, "", eth0},
+				{"os/net/tx_bytes", "counter", 2000, "", eth0},
+				{"os/net/tx_packets", "counter", 20, "", eth0},
+				{"os/net/tx_errs", "counter", 7, "", eth0},
+				{"os/net/tx_drop", "counter", 8, "", eth0},
+				{"os/net/tx_fifo", "counter", 9, "", eth0},
+				{"os/net/tx_colls", "counter", 10, "", eth0},
+				{"os/net/tx_carrier", "counter", 11, "", eth0},
+				{"os/net/tx_compressed", "counter", 12, "", eth0},
+			},
+		},
+		{
+			// Only the configured VMStat variables, with their types.
+			"vmstat", o.GetVMStatMetrics,
+			[]mm.Metric{
+				{"os/vmstat/nr_dirty", "gauge", 12, "", nil},
+				{"os/vmstat/pgfault", "counter", 3000, "", nil},
+				{"os/vmstat/oom_kill", "counter", 0, "", nil},
+			},
+		},
+	}
+	for _, test := range tests {
+		c := &mm.Collection{}
+		if err := o.read(test.file, c, test.collect); err != nil {
+			t.Errorf("%s: %s", test.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.Metrics, test.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.file, c.Metrics, test.want)
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	o := NewOSCollector("os", DefaultConfig())
+	o.config.ProcDir = "testdata/bad"
+
+	tests := []struct {
+		file    string
+		collect func(*bufio.Scanner, *mm.Collection) error
+		err     string
+	}{
+		{"stat", o.GetStatMetrics, `Cannot parse testdata/bad/stat: strconv.ParseFloat: parsing "x": invalid syntax`},
+		{"meminfo", o.GetMeminfoMetrics, `Cannot parse testdata/bad/meminfo: strconv.ParseFloat: parsing "lots": invalid syntax`},
+		{"loadavg", o.GetLoadavgMetrics, "Cannot parse testdata/bad/loadavg: expected at least 3 fields, got 2"},
+		{"diskstats", o.GetDiskstatsMetrics, `Cannot parse testdata/bad/diskstats: strconv.ParseFloat: parsing "x": invalid syntax`},
+		{"vmstat", o.GetVMStatMetrics, `Cannot parse testdata/bad/vmstat: strconv.ParseFloat: parsing "many": invalid syntax`},
+		{"net/dev", o.GetNetDevMetrics, "open testdata/bad/net/dev: no such file or directory"},
+	}
+	for _, test := range tests {
+		err := o.read(test.file, &mm.Collection{}, test.collect)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: got error %v, expected %s", test.file, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package otlpSink
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Protocols
+const (
+	GRPC = "grpc"
+	HTTP = "http/protobuf"
+)
+
+type Config struct {
+	Endpoint           string                       // host:port for grpc, http(s)://host:port for http/protobuf
+	Protocol           string                       // grpc or http/protobuf
+	Insecure           bool                         // grpc without TLS
+	Headers            map[string]string            // e.g. authentication; masked on /status
+	Gzip               bool                         // compress requests
+	ServiceName        string                       // service.name resource attribute
+	ResourceAttributes map[string]string            // added to every instance
+	Instances          map[string]map[string]string // resource attributes keyed on instance
+	Summaries          bool                         // also send the interval stats as Summary metrics
+	Timeout            int64                        // seconds per request
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Endpoint:    "localhost:4317",
+		Protocol:    GRPC,
+		Insecure:    true,
+		ServiceName: "metrics-collector",
+		Summaries:   true,
+		Timeout:     10,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	if c.Endpoint == "" {
+		return errors.New("Endpoint is not set")
+	}
+	switch c.Protocol {
+	case GRPC:
+		if strings.Contains(c.Endpoint, "://") {
+			return fmt.Errorf("Invalid grpc Endpoint: %s; expected host:port", c.Endpoint)
+		}
+	case HTTP:
+		if !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+			return fmt.Errorf("Invalid %s Endpoint: %s; expected http(s)://host:port", HTTP, c.Endpoint)
+		}
+	default:
+		return fmt.Errorf("Invalid Protocol: %s; expected %s or %s", c.Protocol, GRPC, HTTP)
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("Invalid Timeout: %d", c.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package otlpSink
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"../mm"
+	"../pct"
+)
+
+// OTLP protos, opentelemetry/proto/metrics/v1/metrics.proto et al.  Only the
+// fields we send are listed.
+//
+//	message ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+//	message ResourceMetrics  { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+//	message Resource         { repeated KeyValue attributes = 1; }
+//	message ScopeMetrics     { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+//	message InstrumentationScope { string name = 1; }
+//	message Metric           { string name = 1; oneof data { Gauge gauge = 5; Sum sum = 7; Summary summary = 11; } }
+//	message Gauge            { repeated NumberDataPoint data_points = 1; }
+//	message Sum              { repeated NumberDataPoint data_points = 1; AggregationTemporality aggregation_temporality = 2; bool is_monotonic = 3; }
+//	message Summary          { repeated SummaryDataPoint data_points = 1; }
+//	message NumberDataPoint  { fixed64 start_time_unix_nano = 2; fixed64 time_unix_nano = 3; double as_double = 4; repeated KeyValue attributes = 7; }
+//	message SummaryDataPoint { fixed64 start_time_unix_nano = 2; fixed64 time_unix_nano = 3; fixed64 count = 4; double sum = 5;
+//	                           repeated ValueAtQuantile quantile_values = 6; repeated KeyValue attributes = 7; }
+//	message ValueAtQuantile  { double quantile = 1; double value = 2; }
+//	message KeyValue         { string key = 1; AnyValue value = 2; }
+//	message AnyValue         { oneof value { string string_value = 1; } }
+const (
+	aggregationTemporalityCumulative = 2
+	scopeName                        = "metrics-collector"
+)
+
+// A metric is one OTLP metric: a name and its data points.
+type metric struct {
+	name   string
+	field  int // Metric data field: 5 gauge, 7 sum, 11 summary
+	points []*pct.ProtoBuffer
+}
+
+// exportRequest encodes the report as an ExportMetricsServiceRequest with a
+// ResourceMetrics for every instance:
+//
+//   - gauges are a Gauge of the last value
+//   - counters are a monotonic, cumulative Sum of the last total
+//   - if Summaries, the interval stats of every metric are a Summary named
+//     <name>.interval for gauges and <name>.rate for counters (per second)
+//
+// Metric names use . instead of /, e.g. mysql.threads_running.
+func (s *OTLPSink) exportRequest(report *mm.Report) []byte {
+	start := uint64(report.Ts.UnixNano())
+	end := uint64(report.Ts.Add(time.Duration(report.Duration) * time.Second).UnixNano())
+
+	req := &pct.ProtoBuffer{}
+	for _, is := range report.Stats {
+		metrics := map[string]*metric{}
+		names := []string{}
+		add := func(name string, field int, point *pct.ProtoBuffer) {
+			m, ok := metrics[name]
+			if !ok {
+				m = &metric{name: name, field: field}
+				metrics[name] = m
+				names = append(names, name)
+			}
+			m.points = append(m.points, point)
+		}
+
+		for _, stats := range is.Stats {
+			name := strings.Replace(stats.Name, "/", ".", -1)
+			point := &pct.ProtoBuffer{}
+			if stats.Type == "counter" {
+				point.Fixed64(2, uint64(time.Unix(stats.Since, 0).UnixNano()))
+				point.Fixed64(3, end)
+				point.Double(4, stats.Last)
+				attributes(point, 7, stats.Labels)
+				add(name, 7, point)
+			} else {
+				point.Fixed64(3, end)
+				point.Double(4, stats.Last)
+				attributes(point, 7, stats.Labels)
+				add(name, 5, point)
+			}
+
+			if !s.config.Summaries || stats.Carried {
+				continue // no values this interval to summarize
+			}
+			summary := &pct.ProtoBuffer{}
+			summary.Fixed64(2, start)
+			summary.Fixed64(3, end)
+			summary.Fixed64(4, uint64(stats.Cnt))
+			summary.Double(5, stats.Avg*float64(stats.Cnt))
+			for _, q := range []struct {
+				quantile float64
+				value    float64
+			}{
+				{0, stats.Min},
+				{0.05, stats.Pct5},
+				{0.5, stats.Med},
+				{0.95, stats.Pct95},
+				{1, stats.Max},
+			} {
+				vq := &pct.ProtoBuffer{}
+				if q.quantile != 0 {
+					vq.Double(1, q.quantile)
+				}
+				vq.Double(2, q.value)
+				summary.Message(6, vq)
+			}
+			attributes(summary, 7, stats.Labels)
+			if stats.Type == "counter" {
+				add(name+".rate", 11, summary)
+			} else {
+				add(name+".interval", 11, summary)
+			}
+		}
+
+		if len(names) == 0 {
+			continue
+		}
+		scope := &pct.ProtoBuffer{}
+		scopeInfo := &pct.ProtoBuffer{}
+		scopeInfo.String(1, scopeName)
+		scope.Message(1, scopeInfo)
+		sort.Strings(names)
+		for _, name := range names {
+			m := metrics[name]
+			data := &pct.ProtoBuffer{}
+			for _, point := range m.points {
+				data.Message(1, point)
+			}
+			if m.field == 7 {
+				data.Varint(2, aggregationTemporalityCumulative)
+				data.Bool(3, true)
+			}
+			pbMetric := &pct.ProtoBuffer{}
+			pbMetric.String(1, m.name)
+			pbMetric.Message(m.field, data)
+			scope.Message(2, pbMetric)
+		}
+
+		resource := &pct.ProtoBuffer{}
+		attributes(resource, 1, s.resourceAttributes(is))
+		rm := &pct.ProtoBuffer{}
+		rm.Message(1, resource)
+		rm.Message(2, scope)
+		req.Message(1, rm)
+	}
+	return req.Bytes()
+}
+
+// resourceAttributes returns the attributes of the instance: service.name,
+// service.instance.id (the instance), host.name, db.system if it has MySQL
+// metrics, then the configured ResourceAttributes and Instances attributes.
+func (s *OTLPSink) resourceAttributes(is *mm.InstanceStats) map[string]string {
+	attrs := map[string]string{
+		"service.name":        s.config.ServiceName,
+		"service.instance.id": is.Instance,
+		"host.name":           s.hostname,
+	}
+	for _, stats := range is.Stats {
+		if strings.HasPrefix(stats.Name, "mysql/") {
+			attrs["db.system"] = "mysql"
+			break
+		}
+	}
+	for k, v := range s.config.ResourceAttributes {
+		attrs[k] = v
+	}
+	for k, v := range s.config.Instances[is.Instance] {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// attributes adds a KeyValue with a string value for every attribute,
+// sorted by key.
+func attributes(p *pct.ProtoBuffer, field int, attrs map[string]string) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := &pct.ProtoBuffer{}
+		value.String(1, attrs[k])
+		kv := &pct.ProtoBuffer{}
+		kv.String(1, k)
+		kv.Message(2, value)
+		p.Message(field, kv)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
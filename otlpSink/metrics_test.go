@@ -0,0 +1,252 @@
+package otlpSink
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"../mm"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// metricsProto is opentelemetry/proto/collector/metrics/v1/metrics_service.proto,
+// metrics/v1/metrics.proto, resource/v1/resource.proto and
+// common/v1/common.proto, the fields we send, as one FileDescriptorProto.
+const metricsProto = `
+name: "metrics.proto"
+package: "otlp"
+syntax: "proto3"
+message_type {
+  name: "ExportMetricsServiceRequest"
+  field { name: "resource_metrics" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ResourceMetrics" }
+}
+message_type {
+  name: "ResourceMetrics"
+  field { name: "resource" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Resource" }
+  field { name: "scope_metrics" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ScopeMetrics" }
+}
+message_type {
+  name: "Resource"
+  field { name: "attributes" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+}
+message_type {
+  name: "ScopeMetrics"
+  field { name: "scope" number: 1 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.InstrumentationScope" }
+  field { name: "metrics" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.Metric" }
+}
+message_type {
+  name: "InstrumentationScope"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+}
+message_type {
+  name: "Metric"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "gauge" number: 5 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Gauge" oneof_index: 0 }
+  field { name: "sum" number: 7 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Sum" oneof_index: 0 }
+  field { name: "summary" number: 11 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.Summary" oneof_index: 0 }
+  oneof_decl { name: "data" }
+}
+message_type {
+  name: "Gauge"
+  field { name: "data_points" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.NumberDataPoint" }
+}
+message_type {
+  name: "Sum"
+  field { name: "data_points" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.NumberDataPoint" }
+  field { name: "aggregation_temporality" number: 2 label: LABEL_OPTIONAL type: TYPE_ENUM type_name: ".otlp.AggregationTemporality" }
+  field { name: "is_monotonic" number: 3 label: LABEL_OPTIONAL type: TYPE_BOOL }
+}
+message_type {
+  name: "Summary"
+  field { name: "data_points" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.SummaryDataPoint" }
+}
+message_type {
+  name: "NumberDataPoint"
+  field { name: "start_time_unix_nano" number: 2 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "time_unix_nano" number: 3 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "as_double" number: 4 label: LABEL_OPTIONAL type: TYPE_DOUBLE oneof_index: 0 }
+  field { name: "attributes" number: 7 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+  oneof_decl { name: "value" }
+}
+message_type {
+  name: "SummaryDataPoint"
+  field { name: "start_time_unix_nano" number: 2 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "time_unix_nano" number: 3 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "count" number: 4 label: LABEL_OPTIONAL type: TYPE_FIXED64 }
+  field { name: "sum" number: 5 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+  field { name: "quantile_values" number: 6 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.ValueAtQuantile" }
+  field { name: "attributes" number: 7 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".otlp.KeyValue" }
+}
+message_type {
+  name: "ValueAtQuantile"
+  field { name: "quantile" number: 1 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+  field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+}
+message_type {
+  name: "KeyValue"
+  field { name: "key" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_MESSAGE type_name: ".otlp.AnyValue" }
+}
+message_type {
+  name: "AnyValue"
+  field { name: "string_value" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING oneof_index: 0 }
+  oneof_decl { name: "value" }
+}
+enum_type {
+  name: "AggregationTemporality"
+  value { name: "AGGREGATION_TEMPORALITY_UNSPECIFIED" number: 0 }
+  value { name: "AGGREGATION_TEMPORALITY_DELTA" number: 1 }
+  value { name: "AGGREGATION_TEMPORALITY_CUMULATIVE" number: 2 }
+}
+`
+
+func TestExportRequest(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal([]byte(metricsProto), fd); err != nil {
+		t.Fatal(err)
+	}
+	file, err := protodesc.NewFile(fd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestDesc := file.Messages().ByName("ExportMetricsServiceRequest")
+
+	s := &OTLPSink{config: DefaultConfig(), hostname: "host1"}
+	report := &mm.Report{
+		Ts:       time.Unix(1600000000, 0),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				Instance: "db1",
+				Stats: map[string]*mm.Stats{
+					"mysql/threads_running": {Name: "mysql/threads_running", Type: "gauge", Last: 2,
+						Cnt: 2, Min: 1, Pct5: 1, Avg: 1.5, Med: 2, Pct95: 2, Max: 2},
+					"mysql/questions": {Name: "mysql/questions", Labels: map[string]string{"x": "y"}, Type: "counter", Last: 5000, Since: 1599990000,
+						Cnt: 2, Min: 10, Pct5: 10, Avg: 15, Med: 20, Pct95: 20, Max: 20},
+					// Carried forward from the last interval: the last value,
+					// but no summary since the interval had no values.
+					"mysql/table/rows": {Name: "mysql/table/rows", Type: "gauge", Last: 100,
+						Min: 100, Pct5: 100, Avg: 100, Med: 100, Pct95: 100, Max: 100, Carried: true},
+				},
+			},
+		},
+	}
+	got := s.exportRequest(report)
+
+	expect := dynamicpb.NewMessage(requestDesc)
+	err = prototext.Unmarshal([]byte(`
+resource_metrics {
+  resource {
+    attributes { key: "db.system" value { string_value: "mysql" } }
+    attributes { key: "host.name" value { string_value: "host1" } }
+    attributes { key: "service.instance.id" value { string_value: "db1" } }
+    attributes { key: "service.name" value { string_value: "metrics-collector" } }
+  }
+  scope_metrics {
+    scope { name: "metrics-collector" }
+    metrics {
+      name: "mysql.questions"
+      sum {
+        data_points {
+          start_time_unix_nano: 1599990000000000000
+          time_unix_nano: 1600000060000000000
+          as_double: 5000
+          attributes { key: "x" value { string_value: "y" } }
+        }
+        aggregation_temporality: AGGREGATION_TEMPORALITY_CUMULATIVE
+        is_monotonic: true
+      }
+    }
+    metrics {
+      name: "mysql.questions.rate"
+      summary {
+        data_points {
+          start_time_unix_nano: 1600000000000000000
+          time_unix_nano: 1600000060000000000
+          count: 2
+          sum: 30
+          quantile_values { value: 10 }
+          quantile_values { quantile: 0.05 value: 10 }
+          quantile_values { quantile: 0.5 value: 20 }
+          quantile_values { quantile: 0.95 value: 20 }
+          quantile_values { quantile: 1 value: 20 }
+          attributes { key: "x" value { string_value: "y" } }
+        }
+      }
+    }
+    metrics {
+      name: "mysql.table.rows"
+      gauge {
+        data_points { time_unix_nano: 1600000060000000000 as_double: 100 }
+      }
+    }
+    metrics {
+      name: "mysql.threads_running"
+      gauge {
+        data_points { time_unix_nano: 1600000060000000000 as_double: 2 }
+      }
+    }
+    metrics {
+      name: "mysql.threads_running.interval"
+      summary {
+        data_points {
+          start_time_unix_nano: 1600000000000000000
+          time_unix_nano: 1600000060000000000
+          count: 2
+          sum: 3
+          quantile_values { value: 1 }
+          quantile_values { quantile: 0.05 value: 1 }
+          quantile_values { quantile: 0.5 value: 2 }
+          quantile_values { quantile: 0.95 value: 2 }
+          quantile_values { quantile: 1 value: 2 }
+        }
+      }
+    }
+  }
+}`), expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoded, fields with the wrong number are unknown fields, so it's not
+	// equal.  The golden bytes are a literal, not expect marshaled, because
+	// dynamicpb puts oneof fields last while generated code, like us, keeps
+	// field number order.
+	decoded := dynamicpb.NewMessage(requestDesc)
+	if err := proto.Unmarshal(got, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(decoded, expect) {
+		t.Errorf("got:\n%s\nexpected:\n%s", prototext.Format(decoded), prototext.Format(expect))
+	}
+	if hex.EncodeToString(got) != exportRequestGolden {
+		t.Errorf("got bytes:\n%x\nexpected:\n%s", got, exportRequestGolden)
+	}
+}
+
+// The bytes of the request in TestExportRequest, decoded with metricsProto.
+const exportRequestGolden = "" +
+	"0af1040a6f0a140a0964622e73797374656d12070a056d7973716c0a140a0968" +
+	"6f73742e6e616d6512070a05686f7374310a1c0a13736572766963652e696e73" +
+	"74616e63652e696412050a036462310a230a0c736572766963652e6e616d6512" +
+	"130a116d6574726963732d636f6c6c6563746f7212fd030a130a116d65747269" +
+	"63732d636f6c6c6563746f72123e0a0f6d7973716c2e7175657374696f6e733a" +
+	"2b0a251100602d8a6d4e3416190058e7d09357341621000000000088b3403a08" +
+	"0a017812030a01791002180112a5010a146d7973716c2e7175657374696f6e73" +
+	"2e726174655a8c010a8901110000a0d885573416190058e7d093573416210200" +
+	"000000000000290000000000003e4032091100000000000024403212099a9999" +
+	"999999a93f110000000000002440321209000000000000e03f11000000000000" +
+	"3440321209666666666666ee3f110000000000003440321209000000000000f0" +
+	"3f1100000000000034403a080a017812030a017912280a106d7973716c2e7461" +
+	"626c652e726f77732a140a12190058e7d093573416210000000000005940122d" +
+	"0a156d7973716c2e746872656164735f72756e6e696e672a140a12190058e7d0" +
+	"9357341621000000000000004012a4010a1e6d7973716c2e746872656164735f" +
+	"72756e6e696e672e696e74657276616c5a81010a7f110000a0d8855734161900" +
+	"58e7d09357341621020000000000000029000000000000084032091100000000" +
+	"0000f03f3212099a9999999999a93f11000000000000f03f3212090000000000" +
+	"00e03f110000000000000040321209666666666666ee3f110000000000000040" +
+	"321209000000000000f03f110000000000000040"
@@ -0,0 +1,197 @@
+package otlpSink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	mm.RegisterSink("otlp", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewOTLPSink(name, config)
+	})
+}
+
+const exportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// OTLPSink exports reports to an OpenTelemetry collector over OTLP/gRPC or
+// OTLP/HTTP with protobuf encoding.  See exportRequest for how metrics are
+// mapped.  Events aren't sent.
+type OTLPSink struct {
+	name       string
+	config     *Config
+	hostname   string
+	conn       *grpc.ClientConn // grpc
+	client     *http.Client     // http/protobuf
+	selfLabels map[string]string
+}
+
+func NewOTLPSink(name string, config *Config) (*OTLPSink, error) {
+	s := &OTLPSink{
+		name:       name,
+		config:     config,
+		hostname:   hostname(),
+		selfLabels: map[string]string{"sink": name},
+	}
+	if config.Protocol == GRPC {
+		creds := insecure.NewCredentials()
+		if !config.Insecure {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+		// The connection is made lazily and remade as needed by grpc.
+		conn, err := grpc.NewClient(config.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	} else {
+		s.client = &http.Client{Timeout: time.Duration(config.Timeout) * time.Second}
+	}
+	return s, nil
+}
+
+func (s *OTLPSink) Write(service string, report *mm.Report) error {
+	log.Debug("OTLPSink.Write:call")
+	defer log.Debug("OTLPSink.Write:return")
+
+	if len(report.Stats) == 0 {
+		return nil
+	}
+	req := s.exportRequest(report)
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+	var retry bool
+	var err error
+	if s.conn != nil {
+		retry, err = s.sendGRPC(req)
+	} else {
+		retry, err = s.sendHTTP(req)
+	}
+	if err == nil {
+		return nil
+	}
+	mm.Self.Inc("self/sink/errors", s.selfLabels)
+	if !retry {
+		return mm.ErrNoRetry{Err: err}
+	}
+	return err
+}
+
+func (s *OTLPSink) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// sendGRPC calls MetricsService.Export once.  It returns true if the call can
+// be retried.
+func (s *OTLPSink) sendGRPC(req []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.Timeout)*time.Second)
+	defer cancel()
+	if len(s.config.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(s.config.Headers))
+	}
+	opts := []grpc.CallOption{grpc.ForceCodec(rawCodec{})}
+	if s.config.Gzip {
+		opts = append(opts, grpc.UseCompressor(grpcgzip.Name))
+	}
+	var resp []byte
+	err := s.conn.Invoke(ctx, exportMethod, &req, &resp, opts...)
+	if err == nil {
+		return false, nil
+	}
+	// Retryable codes per the OTLP spec.
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true, err
+	}
+	return false, err
+}
+
+// sendHTTP posts to /v1/metrics once.  It returns true if the request can be
+// retried.
+func (s *OTLPSink) sendHTTP(req []byte) (bool, error) {
+	body := req
+	if s.config.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(req)
+		if err := gz.Close(); err != nil {
+			return false, err
+		}
+		body = buf.Bytes()
+	}
+
+	url := strings.TrimRight(s.config.Endpoint, "/") + "/v1/metrics"
+	httpReq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if s.config.Gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range s.config.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	// Retryable statuses per the OTLP spec.
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, err
+	}
+	return false, err
+}
+
+// rawCodec sends and receives messages already encoded as protobuf, so the
+// request encoded by exportRequest can be sent without generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -8,6 +8,7 @@ import (
 
 type Backoff struct {
 	try         int
+	last        time.Duration
 	lastSuccess time.Time
 	resetAfter  time.Duration
 	NowFunc     func() time.Time
@@ -34,7 +35,13 @@ func (b *Backoff) Wait() time.Duration {
 		// [1m30s, 3m)
 		t = int(90 + (90 * rand.Float64()))
 	}
-	return time.Duration(t) * time.Second
+	b.last = time.Duration(t) * time.Second
+	return b.last
+}
+
+// Last returns the last wait returned by Wait.
+func (b *Backoff) Last() time.Duration {
+	return b.last
 }
 
 func (b *Backoff) Success() {
@@ -0,0 +1,86 @@
+package pct
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protobuf wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// A ProtoBuffer encodes a protocol buffers message field by field.  It's
+// for the few, small messages sinks send (e.g. Prometheus remote_write), so
+// they don't need generated code.  Like generated code, callers should add
+// fields in field number order and skip zero values, except oneof fields.
+type ProtoBuffer struct {
+	buf []byte
+}
+
+func (p *ProtoBuffer) Bytes() []byte {
+	return p.buf
+}
+
+func (p *ProtoBuffer) Len() int {
+	return len(p.buf)
+}
+
+func (p *ProtoBuffer) Varint(field int, v uint64) {
+	p.tag(field, protoVarint)
+	p.varint(v)
+}
+
+func (p *ProtoBuffer) Int64(field int, v int64) {
+	p.Varint(field, uint64(v))
+}
+
+func (p *ProtoBuffer) Bool(field int, v bool) {
+	if v {
+		p.Varint(field, 1)
+	} else {
+		p.Varint(field, 0)
+	}
+}
+
+func (p *ProtoBuffer) Fixed64(field int, v uint64) {
+	p.tag(field, protoFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	p.buf = append(p.buf, b[:]...)
+}
+
+func (p *ProtoBuffer) Double(field int, v float64) {
+	p.Fixed64(field, math.Float64bits(v))
+}
+
+func (p *ProtoBuffer) String(field int, s string) {
+	p.tag(field, protoBytes)
+	p.varint(uint64(len(s)))
+	p.buf = append(p.buf, s...)
+}
+
+func (p *ProtoBuffer) RawBytes(field int, b []byte) {
+	p.tag(field, protoBytes)
+	p.varint(uint64(len(b)))
+	p.buf = append(p.buf, b...)
+}
+
+// Message adds the embedded message m.
+func (p *ProtoBuffer) Message(field int, m *ProtoBuffer) {
+	p.RawBytes(field, m.buf)
+}
+
+func (p *ProtoBuffer) tag(field int, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *ProtoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		p.buf = append(p.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	p.buf = append(p.buf, byte(v))
+}
@@ -0,0 +1,46 @@
+package pct
+
+import (
+	"sync"
+)
+
+// Status is a thread-safe set of status messages keyed on process name.
+type Status struct {
+	status map[string]string
+	mux    *sync.RWMutex
+}
+
+func NewStatus(procs []string) *Status {
+	status := make(map[string]string)
+	for _, proc := range procs {
+		status[proc] = ""
+	}
+	s := &Status{
+		status: status,
+		mux:    &sync.RWMutex{},
+	}
+	return s
+}
+
+func (s *Status) Update(proc string, status string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.status[proc] = status
+}
+
+func (s *Status) Get(proc string) string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.status[proc]
+}
+
+// All returns a copy of every status.
+func (s *Status) All() map[string]string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	all := make(map[string]string)
+	for proc, status := range s.status {
+		all[proc] = status
+	}
+	return all
+}
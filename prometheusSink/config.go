@@ -0,0 +1,55 @@
+package prometheusSink
+
+import (
+	"errors"
+	"fmt"
+
+	"../mm"
+)
+
+type Config struct {
+	URL            string            // remote_write endpoint, e.g. http://cortex/api/v1/push
+	Username       string            // basic auth
+	Password       string            // basic auth
+	BearerToken    string            // instead of basic auth
+	Headers        map[string]string // extra headers, e.g. X-Scope-OrgID for Cortex and Mimir
+	ExternalLabels map[string]string // added to every series, e.g. cluster
+	Stats          []string          // stats to send: cnt, min, pct5, avg, med, pct95, max
+	MaxSamples     int               // samples per request
+	Timeout        int64             // seconds per request
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Stats:      []string{"avg", "min", "max", "pct95"},
+		MaxSamples: 2000,
+		Timeout:    30,
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	if c.URL == "" {
+		return errors.New("URL is not set")
+	}
+	if len(c.Stats) == 0 {
+		return errors.New("Stats is empty")
+	}
+	for _, stat := range c.Stats {
+		if _, ok := (&mm.Stats{}).Stat(stat); !ok {
+			return fmt.Errorf("Invalid stat: %s", stat)
+		}
+	}
+	for name := range c.ExternalLabels {
+		if !validLabelName(name) {
+			return fmt.Errorf("Invalid external label name: %s", name)
+		}
+	}
+	if c.MaxSamples < 1 {
+		return fmt.Errorf("Invalid MaxSamples: %d", c.MaxSamples)
+	}
+	if c.Timeout < 1 {
+		return fmt.Errorf("Invalid Timeout: %d", c.Timeout)
+	}
+	return nil
+}
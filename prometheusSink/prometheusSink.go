@@ -0,0 +1,233 @@
+package prometheusSink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"../mm"
+	"../pct"
+	log "github.com/Sirupsen/logrus"
+	"github.com/golang/snappy"
+)
+
+func init() {
+	mm.RegisterSink("prometheus", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewPrometheusSink(name, config), nil
+	})
+}
+
+// PrometheusSink pushes reports to a Prometheus remote_write endpoint, e.g.
+// Thanos receive, Cortex or Mimir, so collectors that can't be scraped can
+// still be stored there.  Every stat of every metric is a series named like
+// mysql_threads_running_avg with the metric labels plus instance:
+//
+//	mysql_table_rows_max{instance="db1",schema="db1",table="t1"}
+//
+// Samples are timestamped at the start of the report interval.  Events
+// aren't sent.
+type PrometheusSink struct {
+	name       string
+	config     *Config
+	client     *http.Client
+	selfLabels map[string]string
+}
+
+func NewPrometheusSink(name string, config *Config) *PrometheusSink {
+	s := &PrometheusSink{
+		name:       name,
+		config:     config,
+		client:     &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		selfLabels: map[string]string{"sink": name},
+	}
+	return s
+}
+
+func (s *PrometheusSink) Write(service string, report *mm.Report) error {
+	log.Debug("PrometheusSink.Write:call")
+	defer log.Debug("PrometheusSink.Write:return")
+
+	// Send every batch even if one fails so as little as possible is lost.
+	series := s.series(report)
+	var firstErr error
+	for len(series) > 0 {
+		n := s.config.MaxSamples
+		if n > len(series) {
+			n = len(series)
+		}
+		if err := s.post(writeRequest(series[:n])); err != nil {
+			mm.Self.Inc("self/sink/errors", s.selfLabels)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		series = series[n:]
+	}
+	return firstErr
+}
+
+func (s *PrometheusSink) Close() {
+}
+
+// post sends the request.  Only network errors and 5xx and 429 responses
+// are worth retrying; other errors are mm.ErrNoRetry.
+func (s *PrometheusSink) post(req []byte) error {
+	body := snappy.Encode(nil, req)
+	mm.Self.Inc("self/sink/requests", s.selfLabels)
+	retry, err := s.send(body)
+	if err != nil && !retry {
+		return mm.ErrNoRetry{Err: err}
+	}
+	return err
+}
+
+// send posts the body once.  It returns true if the request can be retried.
+func (s *PrometheusSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.BearerToken)
+	} else if s.config.Username != "" {
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s returned %s: %s", s.config.URL, resp.Status, strings.TrimSpace(string(msg)))
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// --------------------------------------------------------------------------
+// remote_write protocol
+// --------------------------------------------------------------------------
+
+type label struct {
+	name  string
+	value string
+}
+
+// A timeSeries has one sample because a report has one value per stat.
+type timeSeries struct {
+	labels []label // sorted by name
+	value  float64
+	ts     int64 // Unix ms
+}
+
+// series returns a time series for every configured stat of every metric.
+func (s *PrometheusSink) series(report *mm.Report) []timeSeries {
+	series := []timeSeries{}
+	ts := report.Ts.UnixNano() / int64(time.Millisecond)
+	for _, is := range report.Stats {
+		for _, stats := range is.Stats {
+			for _, stat := range s.config.Stats {
+				value, _ := stats.Stat(stat)
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					continue
+				}
+				labels := map[string]string{}
+				for k, v := range s.config.ExternalLabels {
+					labels[k] = v
+				}
+				for k, v := range stats.Labels {
+					labels[sanitize(k)] = v
+				}
+				labels["instance"] = is.Instance
+				labels["__name__"] = sanitize(stats.Name + "_" + stat)
+				series = append(series, timeSeries{sortLabels(labels), value, ts})
+			}
+		}
+	}
+	return series
+}
+
+// writeRequest encodes a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+func writeRequest(series []timeSeries) []byte {
+	req := &pct.ProtoBuffer{}
+	for _, ts := range series {
+		pbSeries := &pct.ProtoBuffer{}
+		for _, l := range ts.labels {
+			pbLabel := &pct.ProtoBuffer{}
+			pbLabel.String(1, l.name)
+			pbLabel.String(2, l.value)
+			pbSeries.Message(1, pbLabel)
+		}
+		pbSample := &pct.ProtoBuffer{}
+		pbSample.Double(1, ts.value)
+		pbSample.Int64(2, ts.ts)
+		pbSeries.Message(2, pbSample)
+		req.Message(1, pbSeries)
+	}
+	return req.Bytes()
+}
+
+// sortLabels returns the labels sorted by name, without empty values which
+// Prometheus treats as no label.
+func sortLabels(labels map[string]string) []label {
+	sorted := make([]label, 0, len(labels))
+	for name, value := range labels {
+		if value != "" {
+			sorted = append(sorted, label{name, value})
+		}
+	}
+	sort.Sort(byName(sorted))
+	return sorted
+}
+
+type byName []label
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byName) Less(i, j int) bool { return l[i].name < l[j].name }
+
+// sanitize makes s a valid Prometheus metric or label name by replacing
+// invalid characters with _, e.g. mysql/innodb/x becomes mysql_innodb_x.
+func sanitize(s string) string {
+	name := []byte(s)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func validLabelName(s string) bool {
+	return s != "" && sanitize(s) == s && !strings.HasPrefix(s, "__")
+}
@@ -0,0 +1,110 @@
+package prometheusSink
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"../mm"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// remoteProto is prometheus/prompb/remote.proto and types.proto, the fields
+// we send, as a FileDescriptorProto.
+const remoteProto = `
+name: "remote.proto"
+package: "prometheus"
+syntax: "proto3"
+message_type {
+  name: "WriteRequest"
+  field { name: "timeseries" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".prometheus.TimeSeries" }
+}
+message_type {
+  name: "TimeSeries"
+  field { name: "labels" number: 1 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".prometheus.Label" }
+  field { name: "samples" number: 2 label: LABEL_REPEATED type: TYPE_MESSAGE type_name: ".prometheus.Sample" }
+}
+message_type {
+  name: "Label"
+  field { name: "name" number: 1 label: LABEL_OPTIONAL type: TYPE_STRING }
+  field { name: "value" number: 2 label: LABEL_OPTIONAL type: TYPE_STRING }
+}
+message_type {
+  name: "Sample"
+  field { name: "value" number: 1 label: LABEL_OPTIONAL type: TYPE_DOUBLE }
+  field { name: "timestamp" number: 2 label: LABEL_OPTIONAL type: TYPE_INT64 }
+}
+`
+
+func TestWriteRequest(t *testing.T) {
+	fd := &descriptorpb.FileDescriptorProto{}
+	if err := prototext.Unmarshal([]byte(remoteProto), fd); err != nil {
+		t.Fatal(err)
+	}
+	file, err := protodesc.NewFile(fd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRequestDesc := file.Messages().ByName("WriteRequest")
+
+	config := DefaultConfig()
+	config.Stats = []string{"avg", "max"}
+	config.ExternalLabels = map[string]string{"cluster": "c1"}
+	s := NewPrometheusSink("prometheus", config)
+	report := &mm.Report{
+		Ts:       time.Unix(1600000000, 0),
+		Duration: 60,
+		Stats: []*mm.InstanceStats{
+			{
+				Instance: "db1",
+				Stats: map[string]*mm.Stats{
+					"mysql/table/rows": {Name: "mysql/table/rows", Labels: map[string]string{"schema": "db1", "table": "t1"}, Type: "gauge", Cnt: 2, Avg: 1.5, Max: 2},
+				},
+			},
+		},
+	}
+	got := writeRequest(s.series(report))
+
+	expect := dynamicpb.NewMessage(writeRequestDesc)
+	err = prototext.Unmarshal([]byte(`
+timeseries {
+  labels { name: "__name__" value: "mysql_table_rows_avg" }
+  labels { name: "cluster" value: "c1" }
+  labels { name: "instance" value: "db1" }
+  labels { name: "schema" value: "db1" }
+  labels { name: "table" value: "t1" }
+  samples { value: 1.5 timestamp: 1600000000000 }
+}
+timeseries {
+  labels { name: "__name__" value: "mysql_table_rows_max" }
+  labels { name: "cluster" value: "c1" }
+  labels { name: "instance" value: "db1" }
+  labels { name: "schema" value: "db1" }
+  labels { name: "table" value: "t1" }
+  samples { value: 2 timestamp: 1600000000000 }
+}`), expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoded, fields with the wrong number are unknown fields, so it's not
+	// equal; and the bytes are what generated code would encode.
+	decoded := dynamicpb.NewMessage(writeRequestDesc)
+	if err := proto.Unmarshal(got, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(decoded, expect) {
+		t.Errorf("got:\n%s\nexpected:\n%s", prototext.Format(decoded), prototext.Format(expect))
+	}
+	golden, err := proto.MarshalOptions{Deterministic: true}.Marshal(expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, golden) {
+		t.Errorf("got bytes:\n%x\nexpected:\n%x", got, golden)
+	}
+}
@@ -0,0 +1,39 @@
+package statsdSink
+
+import (
+	"fmt"
+)
+
+type Config struct {
+	Addr       string            // statsd agent host:port, or socket path for unixgram
+	Network    string            // udp or unixgram (DogStatsD socket)
+	Prefix     string            // prepended to every name, e.g. mm.
+	Tags       bool              // DogStatsD tags; without tags, instance and labels are part of the name
+	GlobalTags map[string]string // added to every metric if Tags
+	MaxPacket  int               // bytes per datagram
+}
+
+func DefaultConfig() *Config {
+	c := &Config{
+		Addr:      "localhost:8125",
+		Network:   "udp",
+		Tags:      true,
+		MaxPacket: 1432, // fits a 1500 byte MTU
+	}
+	return c
+}
+
+func (c *Config) validate() error {
+	switch c.Network {
+	case "udp", "unixgram":
+	default:
+		return fmt.Errorf("Invalid Network: %s; expected udp or unixgram", c.Network)
+	}
+	if c.Addr == "" {
+		return fmt.Errorf("Addr is not set")
+	}
+	if c.MaxPacket < 512 {
+		return fmt.Errorf("Invalid MaxPacket: %d; must be >= 512", c.MaxPacket)
+	}
+	return nil
+}
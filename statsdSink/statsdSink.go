@@ -0,0 +1,222 @@
+package statsdSink
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"../mm"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	mm.RegisterSink("statsd", func(name string, data []byte) (mm.Sink, error) {
+		config := DefaultConfig()
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, config); err != nil {
+				return nil, err
+			}
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		return NewStatsDSink(name, config)
+	})
+}
+
+// StatsDSink sends every collected value, not the interval stats, to a
+// StatsD or DogStatsD agent: gauges as gauges and counters as counts of the
+// increase since the previous collection.  With Tags, names are like
+// mm.mysql.threads_running with instance and labels as tags; without, they're
+// like mm.db1.mysql.threads_running.schema.db1.
+//
+// Reports are ignored; the agent aggregates the values itself.  The agent is
+// dialed on the first write, and again after a write fails, so it can start
+// or restart after the collector.
+type StatsDSink struct {
+	name       string
+	config     *Config
+	conn       net.Conn
+	mux        *sync.Mutex // guards conn
+	globalTags []string
+	last       map[string]float64 // last counter values, keyed on instance and metric key
+	selfLabels map[string]string
+}
+
+func NewStatsDSink(name string, config *Config) (*StatsDSink, error) {
+	s := &StatsDSink{
+		name:       name,
+		config:     config,
+		mux:        &sync.Mutex{},
+		globalTags: tags(config.GlobalTags),
+		last:       make(map[string]float64),
+		selfLabels: map[string]string{"sink": name},
+	}
+	return s, nil
+}
+
+func (s *StatsDSink) Write(service string, report *mm.Report) error {
+	return nil
+}
+
+func (s *StatsDSink) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *StatsDSink) WriteCollection(c *mm.Collection) error {
+	var packet []byte
+	var firstErr error
+	send := func() {
+		if len(packet) == 0 {
+			return
+		}
+		mm.Self.Inc("self/sink/requests", s.selfLabels)
+		if err := s.send(packet); err != nil {
+			mm.Self.Inc("self/sink/errors", s.selfLabels)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		packet = packet[:0]
+	}
+
+	for _, m := range c.Metrics {
+		for _, line := range s.lines(c.Instance, &m) {
+			if len(packet) > 0 && len(packet)+1+len(line) > s.config.MaxPacket {
+				send()
+			}
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	send()
+	if firstErr != nil {
+		log.Debug(fmt.Sprintf("Cannot send to statsd %s: %s", s.config.Addr, firstErr))
+	}
+	return firstErr
+}
+
+// send writes the packet, dialing the agent first if need be.  After an
+// error, e.g. the agent's socket is gone because it restarted, the connection
+// is closed so the next send dials again.
+func (s *StatsDSink) send(packet []byte) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.conn == nil {
+		conn, err := net.Dial(s.config.Network, s.config.Addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if _, err := s.conn.Write(packet); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// lines returns the statsd lines for the metric: none for the first value of
+// a counter or when it resets, else one, or two for a negative gauge without
+// tags because plain statsd treats -n as a decrement.
+func (s *StatsDSink) lines(instance string, m *mm.Metric) []string {
+	if math.IsNaN(m.Number) || math.IsInf(m.Number, 0) {
+		return nil
+	}
+
+	value := m.Number
+	statType := "g"
+	if m.Type == "counter" {
+		key := instance + "\x00" + m.Key()
+		last, ok := s.last[key]
+		s.last[key] = m.Number
+		if !ok || m.Number < last {
+			return nil
+		}
+		value = m.Number - last
+		statType = "c"
+	}
+
+	name := s.config.Prefix
+	suffix := "|" + statType
+	if s.config.Tags {
+		name += strings.Replace(m.Name, "/", ".", -1)
+		t := append([]string{"instance:" + instance}, s.globalTags...)
+		t = append(t, tags(m.Labels)...)
+		suffix += "|#" + strings.Join(t, ",")
+	} else {
+		name += nameNode(instance) + "." + strings.Replace(m.Name, "/", ".", -1) + labelNodes(m.Labels)
+	}
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + suffix
+	if statType == "g" && value < 0 && !s.config.Tags {
+		return []string{name + ":0" + suffix, line}
+	}
+	return []string{line}
+}
+
+// tags returns DogStatsD k:v tags sorted by key.
+func tags(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	t := make([]string, len(keys))
+	for i, k := range keys {
+		t[i] = tagValue(k) + ":" + tagValue(labels[k])
+	}
+	return t
+}
+
+// tagValue removes the characters that separate tags and fields.
+func tagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// labelNodes returns ".key.value" for every label, sorted by key.
+func labelNodes(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	nodes := ""
+	for _, k := range keys {
+		nodes += "." + nameNode(k) + "." + nameNode(labels[k])
+	}
+	return nodes
+}
+
+func nameNode(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Replace(strings.Replace(s, ".", "_", -1), "/", "_", -1)
+}
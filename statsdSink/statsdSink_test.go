@@ -0,0 +1,77 @@
+package statsdSink
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"../mm"
+)
+
+func TestAgentRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsdSink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "dsd.socket")
+
+	// The agent isn't running yet.
+	config := DefaultConfig()
+	config.Network = "unixgram"
+	config.Addr = socket
+	s, err := NewStatsDSink("statsd", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := &mm.Collection{
+		Instance: "db1",
+		Metrics:  []mm.Metric{{Name: "mysql/threads_running", Type: "gauge", Number: 3}},
+	}
+	if err := s.WriteCollection(c); err == nil {
+		t.Error("no error without an agent")
+	}
+
+	listen := func() *net.UnixConn {
+		agent, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return agent
+	}
+	receive := func(agent *net.UnixConn) {
+		buf := make([]byte, 1024)
+		agent.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := agent.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, expect := string(buf[:n]), "mysql.threads_running:3|g|#instance:db1"; got != expect {
+			t.Errorf("got %s, expected %s", got, expect)
+		}
+	}
+
+	agent := listen()
+	if err := s.WriteCollection(c); err != nil {
+		t.Fatal(err)
+	}
+	receive(agent)
+
+	// The agent restarts: the write to the old socket fails, the next one
+	// dials the new socket.
+	agent.Close()
+	os.Remove(socket)
+	agent = listen()
+	defer agent.Close()
+	if err := s.WriteCollection(c); err == nil {
+		t.Error("no error writing to the old socket")
+	}
+	if err := s.WriteCollection(c); err != nil {
+		t.Fatal(err)
+	}
+	receive(agent)
+}